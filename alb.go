@@ -11,22 +11,29 @@
 //		"net/http"
 //
 //		"github.com/MichaelFraser99/alb"
-//		"github.com/aws/aws-lambda-go/lambda"
 //	)
 //
-//	func main() { lambda.Start(alb.Handler(http.HandlerFunc(hello))) }
+//	func main() { alb.Start(http.HandlerFunc(hello)) }
 //
 //	func hello(w http.ResponseWriter, r *http.Request) {
 //		fmt.Fprintln(w, "Hello from AWS Lambda behind ALB")
 //	}
 //
+// Start talks to the Lambda Runtime API itself; Handler can be used with
+// lambda.Start from github.com/aws/aws-lambda-go/lambda instead.
+//
+// For local development, ListenAndServe serves the same handler over HTTP,
+// emulating ALB in front of it. Main chooses between the two, or handling
+// event JSON read from standard input, by the environment.
+//
 // Note: since both request and reply to/from AWS Lambda are passed as
 // json-encoded payloads, their sizes are limited. AWS documentation states
 // that: "The maximum size of the request body that you can send to a Lambda
 // function is 1 MB. [...] The maximum size of the response JSON that the Lambda
 // function can send is 1 MB." The exact limit of response size also depends on
-// whether its body is valid utf8 or not, as non-utf8 payloads are transparently
-// base64-encoded, which adds some overhead.
+// whether its body is sent as text or transparently base64-encoded, which adds
+// some overhead, see WithEncodingPolicy. Responses exceeding the limit are
+// replaced with 500 Internal Server Error, see WithResponseLimit.
 //
 // For further details see
 // https://docs.aws.amazon.com/elasticloadbalancing/latest/application/lambda-functions.html
@@ -36,28 +43,146 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"net/http/httptest"
 	"net/textproto"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 )
 
 // Handler returns a function suitable to use as an AWS Lambda handler with
 // github.com/aws/aws-lambda-go/lambda package.
 //
 // Note that the request is fully cached in memory.
-func Handler(h http.Handler) func(context.Context, request) (*response, error) {
+func Handler(h http.Handler, opts ...Option) func(context.Context, Event) (*Response, error) {
 	if h == nil {
 		panic("Wrap called with nil handler")
 	}
-	hh := lambdaHandler{handler: h}
-	return hh.Run
+	return newLambdaHandler(h, opts).Run
+}
+
+// Invoker is an AWS Lambda handler working with raw event and response JSON.
+// It implements the Handler interface of github.com/aws/aws-lambda-go/lambda
+// package and can be passed to lambda.StartHandler.
+//
+// Invoker decodes events and encodes responses without reflection, and
+// needs less memory than the function returned by Handler with events and
+// responses passed through encoding/json.
+// Responses are encoded without escaping HTML characters, leaving more room
+// for HTML bodies within the limit set with WithResponseLimit.
+type Invoker struct {
+	h *lambdaHandler
+}
+
+// NewInvoker returns Invoker serving requests with h, options are applied as
+// with Handler.
+func NewInvoker(h http.Handler, opts ...Option) *Invoker {
+	if h == nil {
+		panic("NewInvoker called with nil handler")
+	}
+	hh := newLambdaHandler(h, opts)
+	hh.rawJSON = true
+	return &Invoker{h: hh}
+}
+
+// Invoke handles ALB event payload and returns the response JSON.
+func (i *Invoker) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var req Event
+	if err := decodeEvent(payload, &req); err != nil {
+		return nil, fmt.Errorf("alb: decoding event: %w", err)
+	}
+	resp, err := i.h.run(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.release()
+	size := resp.sizeHint
+	if size == 0 {
+		size = resp.size()
+	}
+	return appendResponse(make([]byte, 0, size), resp), nil
+}
+
+func newLambdaHandler(h http.Handler, opts []Option) *lambdaHandler {
+	hh := &lambdaHandler{handler: h}
+	for _, opt := range opts {
+		opt(hh)
+	}
+	return hh
+}
+
+// Option configures optional behaviour of the function returned by Handler.
+type Option func(*lambdaHandler)
+
+// WithTrustedHops sets the number of trusted proxies (such as CloudFront)
+// sitting in front of ALB. The client address is taken from the
+// X-Forwarded-For entry that many positions to the left of the one appended
+// by ALB itself. The default of 0 uses the address ALB saw the connection
+// from.
+func WithTrustedHops(n int) Option {
+	return func(h *lambdaHandler) {
+		if n < 0 {
+			n = 0
+		}
+		h.trustedHops = n
+	}
+}
+
+// WithForwardedProto controls whether X-Forwarded-Proto and Host headers are
+// used to make request URL absolute and, for https requests, to synthesize
+// request TLS connection state from ALB X-Amzn-Tls-* headers. Enabled by
+// default.
+func WithForwardedProto(enabled bool) Option {
+	return func(h *lambdaHandler) { h.ignoreForwardedProto = !enabled }
+}
+
+// WithClientCertPolicy sets how requests carrying malformed ALB mutual TLS
+// client certificate headers are handled, see WithMutualTLS. The default is
+// RejectInvalidClientCert.
+func WithClientCertPolicy(p ClientCertPolicy) Option {
+	return func(h *lambdaHandler) { h.clientCertPolicy = p }
+}
+
+// WithLogger sets logger for diagnostic messages, by default they are written
+// with the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(h *lambdaHandler) { h.logger = l }
+}
+
+// WithErrorClassifier sets function deciding which malformed events are
+// answered with 400 Bad Request. If not set, every malformed event fails the
+// invocation, which ALB reports to the client as 502 Bad Gateway.
+func WithErrorClassifier(f ErrorClassifier) Option {
+	return func(h *lambdaHandler) { h.classifier = f }
+}
+
+// ErrorClassifier reports whether malformed event is caused by the client and
+// should be answered with 400 Bad Request rather than failing the invocation.
+type ErrorClassifier func(err *EventError) bool
+
+// ClientErrors is an ErrorClassifier answering every malformed event with 400
+// Bad Request.
+func ClientErrors(*EventError) bool { return true }
+
+// EventError reports an event that cannot be converted to http.Request, such
+// as one with invalid percent-encoding in its path or invalid base64 body.
+type EventError struct {
+	Field string // event field that failed to decode: "path" or "body"
+	Err   error
 }
 
-type request struct {
+func (e *EventError) Error() string { return "alb: malformed event " + e.Field + ": " + e.Err.Error() }
+func (e *EventError) Unwrap() error { return e.Err }
+
+// Event is the request event Lambda function is invoked with by ALB, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/lambda-functions.html#receive-event-from-load-balancer
+type Event struct {
+	RequestContext    RequestContext      `json:"requestContext"`
 	Method            string              `json:"httpMethod"`
 	Path              string              `json:"path"`
 	Query             map[string]string   `json:"queryStringParameters"`
@@ -68,57 +193,179 @@ type request struct {
 	BodyEncoded       bool                `json:"isBase64Encoded"`
 }
 
-func (r *request) HeadersProvided() map[string][]string {
+// RequestContext holds details of the load balancer that invoked the function.
+type RequestContext struct {
+	ELB ELBContext `json:"elb"`
+}
+
+// ELBContext identifies the target group that invoked the function.
+type ELBContext struct {
+	TargetGroupARN string `json:"targetGroupArn"`
+}
+
+// EventFromContext returns the event being handled. It is available to the
+// handler passed to Handler.
+func EventFromContext(ctx context.Context) (*Event, bool) {
+	e, ok := ctx.Value(eventKey{}).(*Event)
+	return e, ok
+}
+
+type eventKey struct{}
+
+// HeadersProvided returns request headers regardless of whether the target
+// group has multi-value headers enabled.
+func (r *Event) HeadersProvided() map[string][]string {
 	if r.MultiValueHeaders == nil {
-		container := make(map[string][]string, len(r.Headers))
-		for k, v := range r.Headers {
-			container[k] = []string{v}
-		}
-		return container
+		return multiValue(r.Headers)
 	}
 	return r.MultiValueHeaders
 }
 
-func (r *request) QueryProvided() map[string][]string {
+// QueryProvided returns query string parameters regardless of whether the
+// target group has multi-value headers enabled. Keys and values are
+// percent-encoded.
+func (r *Event) QueryProvided() map[string][]string {
 	if r.MultiValueQuery == nil {
-		container := make(map[string][]string, len(r.Query))
-		for k, v := range r.Query {
-			container[k] = []string{v}
-		}
-		return container
+		return multiValue(r.Query)
 	}
 	return r.MultiValueQuery
 }
 
-type response struct {
+// multiValue converts single-value map to multi-value one, allocating
+// values in one go.
+func multiValue(m map[string]string) map[string][]string {
+	container := make(map[string][]string, len(m))
+	values := make([]string, len(m))
+	i := 0
+	for k, v := range m {
+		values[i] = v
+		container[k] = values[i : i+1 : i+1]
+		i++
+	}
+	return container
+}
+
+// Response is the response Lambda function replies to ALB with, see
+// https://docs.aws.amazon.com/elasticloadbalancing/latest/application/lambda-functions.html#respond-to-load-balancer
+//
+// Only one of Headers and MultiValueHeaders is set, depending on whether the
+// target group has multi-value headers enabled.
+type Response struct {
 	StatusCode        int                 `json:"statusCode"`
 	Status            string              `json:"statusDescription"`
 	Headers           map[string]string   `json:"headers"`
 	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
 	Body              string              `json:"body"`
 	BodyEncoded       bool                `json:"isBase64Encoded"`
+
+	// raw holds body to be sent base64-encoded in place of Body, so that
+	// it can be encoded directly into the response JSON. It is put back to
+	// the buffer pool with release.
+	raw *bytes.Buffer
+
+	// sizeHint is the size of the response JSON computed for the limit
+	// check, so that Invoker does not compute it again. Zero if unknown.
+	sizeHint int
 }
 
-func (r *response) SetHeaders(req *request, res *http.Response) {
-	if req.MultiValueHeaders == nil {
-		r.Headers = make(map[string]string, len(res.Header))
-		for k, vv := range res.Header {
-			r.Headers[k] = strings.Join(vv, ",")
-		}
-	} else {
-		r.MultiValueHeaders = res.Header
+// encodeBody sets Body to base64-encoded raw body, if any.
+func (r *Response) encodeBody() {
+	if r.raw != nil {
+		r.Body = base64.StdEncoding.EncodeToString(r.raw.Bytes())
+		r.release()
+	}
+}
+
+// release puts raw body buffer back to the pool.
+func (r *Response) release() {
+	putBuffer(r.raw)
+	r.raw = nil
+}
+
+// body returns reader over unencoded response body and its size.
+func (r *Response) body() (io.Reader, int64) {
+	if r.raw != nil {
+		return bytes.NewReader(r.raw.Bytes()), int64(r.raw.Len())
+	}
+	return strings.NewReader(r.Body), int64(len(r.Body))
+}
+
+// SetHeaders sets response headers in the form matching header mode. Non-nil
+// error describes header values that could not be sent in single-value
+// headers mode.
+func (r *Response) SetHeaders(mode HeaderMode, header http.Header, policy HeaderPolicy) error {
+	if mode != MultiValueHeaders {
+		var err error
+		r.Headers, err = singleValueHeaders(header, policy)
+		return err
 	}
+	r.MultiValueHeaders = header
+	return nil
 }
 
 type lambdaHandler struct {
-	handler http.Handler
+	handler              http.Handler
+	trustedHops          int
+	ignoreForwardedProto bool
+	mtlsMode             MutualTLSMode
+	clientCertPolicy     ClientCertPolicy
+	panicHandler         PanicHandler
+	classifier           ErrorClassifier
+	deadlineMargin       time.Duration
+	timeoutHandler       http.Handler
+	headerPolicy         HeaderPolicy
+	headerMode           HeaderMode
+	responseLimit        int // 0 means DefaultResponseLimit, negative means no limit
+	oversizeHandler      http.Handler
+	blobStore            BlobStore
+	blobRedirect         int
+	compression          *CompressionConfig
+	maxDecompressed      int64
+	encodingPolicy       EncodingPolicy
+	rawJSON              bool // response is serialized with appendResponse, not encoding/json
+	logger               *log.Logger
 }
 
-func (h *lambdaHandler) Run(ctx context.Context, req request) (*response, error) {
-	u, err := buildURL(req.Path, req.QueryProvided())
+func (h *lambdaHandler) Run(ctx context.Context, req Event) (*Response, error) {
+	out, err := h.run(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+	out.encodeBody()
+	return out, nil
+}
+
+// run is like Run, but leaves binary response body for the caller to encode,
+// see Response.raw.
+func (h *lambdaHandler) run(ctx context.Context, req Event) (*Response, error) {
+	r, err := h.request(ctx, &req)
+	if err != nil {
+		return h.eventError(&req, err)
+	}
+	handler := h.handler
+	if r.TLS != nil && h.mtlsMode != MTLSOff {
+		if err := setClientCertificates(r.TLS, r.Header, h.mtlsMode); err != nil {
+			if h.clientCertPolicy == RejectInvalidClientCert {
+				handler = errorHandler(http.StatusBadRequest)
+			} else {
+				r.TLS = nil
+			}
+		}
+	}
+	if h.maxDecompressed != 0 {
+		if code := decompressBody(r, h.maxDecompressed); code != 0 {
+			handler = errorHandler(code)
+		}
+	}
+	return h.serve(&req, r, handler)
+}
+
+// request converts event to http.Request with context derived from ctx.
+func (h *lambdaHandler) request(ctx context.Context, req *Event) (*http.Request, *EventError) {
+	u, err := buildURL(req.Path, req.QueryProvided())
+	if err != nil {
+		return nil, &EventError{Field: "path", Err: err}
+	}
 
 	headers := make(http.Header, len(req.Headers))
 	for k, v := range req.HeadersProvided() {
@@ -132,49 +379,153 @@ func (h *lambdaHandler) Run(ctx context.Context, req request) (*response, error)
 		URL:        u,
 		Header:     headers,
 		Host:       headers.Get("Host"),
+		RemoteAddr: remoteAddr(headers, h.trustedHops),
+	}
+	if !h.ignoreForwardedProto {
+		if scheme := forwardedProto(headers); scheme != "" {
+			if r.Host != "" {
+				u.Scheme, u.Host = scheme, r.Host
+			}
+			if scheme == "https" {
+				r.TLS = forwardedTLS(headers, r.Host)
+			}
+		}
 	}
-	r = r.WithContext(ctx)
+	r = r.WithContext(context.WithValue(ctx, eventKey{}, req))
 	switch {
 	case req.BodyEncoded:
-		b, err := base64.StdEncoding.DecodeString(req.Body)
+		body, n, err := base64Body(req.Body)
 		if err != nil {
-			return nil, err
+			return nil, &EventError{Field: "body", Err: err}
 		}
-		r.Body = io.NopCloser(bytes.NewReader(b))
-		r.ContentLength = int64(len(b))
+		r.Body = io.NopCloser(body)
+		r.ContentLength = n
 	default:
 		r.Body = io.NopCloser(strings.NewReader(req.Body))
 		r.ContentLength = int64(len(req.Body))
 	}
-	recorder := httptest.NewRecorder()
-	h.handler.ServeHTTP(recorder, r)
-	res := recorder.Result()
-	out := &response{
-		StatusCode: res.StatusCode,
-		Status:     res.Status,
+	return r, nil
+}
+
+// serve calls handler and converts its reply to the form expected by ALB.
+func (h *lambdaHandler) serve(req *Event, r *http.Request, handler http.Handler) (*Response, error) {
+	recorder, err := h.callWithDeadline(handler, r)
+	if err != nil {
+		return nil, err
+	}
+	defer recorder.release()
+	if h.compression != nil {
+		h.compress(r, recorder)
 	}
-	out.SetHeaders(&req, res)
-	if b := recorder.Body.Bytes(); utf8.Valid(b) {
-		out.Body = recorder.Body.String()
+	limit := h.maxResponseSize()
+	if recorder.exceeded {
+		return h.oversize(req, r, "over "+strconv.Itoa(limit))
+	}
+	out := h.respond(req, recorder)
+	size := h.responseSize(out)
+	if limit > 0 && size > limit {
+		defer out.release()
+		if h.blobStore != nil && out.StatusCode == http.StatusOK {
+			if out, err := h.offload(req, r, recorder, out); err == nil {
+				return out, nil
+			}
+		}
+		return h.oversize(req, r, strconv.Itoa(size))
+	}
+	out.sizeHint = size
+	return out, nil
+}
+
+// oversize replaces response exceeding size limit with the one written by
+// the configured oversize handler.
+func (h *lambdaHandler) oversize(req *Event, r *http.Request, size string) (*Response, error) {
+	h.logf("alb: response to %s %s is %s bytes, exceeding the limit of %d bytes", r.Method, r.URL, size, h.maxResponseSize())
+	oh := h.oversizeHandler
+	if oh == nil {
+		oh = errorHandler(http.StatusInternalServerError)
+	}
+	recorder, err := h.call(oh, r)
+	if err != nil {
+		return nil, err
+	}
+	defer recorder.release()
+	return h.respond(req, recorder), nil
+}
+
+// eventError either fails the invocation with err or answers it with 400 Bad
+// Request, depending on the configured ErrorClassifier.
+func (h *lambdaHandler) eventError(req *Event, err *EventError) (*Response, error) {
+	if h.classifier == nil || !h.classifier(err) {
+		return nil, err
+	}
+	recorder := h.newResponseWriter()
+	defer recorder.release()
+	errorHandler(http.StatusBadRequest)(recorder, nil)
+	return h.respond(req, recorder), nil
+}
+
+// respond converts recorded reply to the form expected by ALB.
+func (h *lambdaHandler) respond(req *Event, recorder *responseWriter) *Response {
+	code, header := recorder.finish()
+	out := &Response{
+		StatusCode: code,
+		Status:     strconv.Itoa(code) + " " + http.StatusText(code),
+	}
+	if err := out.SetHeaders(h.eventHeaderMode(req), header, h.headerPolicy); err != nil {
+		h.logf("%v", err)
+	}
+	policy := h.encodingPolicy
+	if policy == nil {
+		policy = defaultEncodingPolicy
+	}
+	if b := recorder.bytes(); !recorder.encoded && !policy(header, b) {
+		out.Body = string(b)
 	} else {
-		out.Body = base64.StdEncoding.EncodeToString(b)
+		out.raw, recorder.body = recorder.body, nil
 		out.BodyEncoded = true
 	}
-	return out, nil
+	return out
+}
+
+func (h *lambdaHandler) logf(format string, args ...interface{}) {
+	if h.logger != nil {
+		h.logger.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// errorHandler returns handler replying with plain text status code
+// description.
+func errorHandler(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	}
 }
 
 // buildURL constructs url from already escaped path and query string parameters
 // minimizing allocations and escaping overhead.
 func buildURL(path string, query map[string][]string) (*url.URL, error) {
+	parse := url.Parse
+	if strings.HasPrefix(path, "//") {
+		// parse as in HTTP request line, rather than as host
+		parse = url.ParseRequestURI
+	}
 	if len(query) == 0 {
-		return url.Parse(path)
+		return parse(path)
+	}
+	// sort keys so that RawQuery does not depend on map iteration order
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 	var b strings.Builder
 	b.WriteString(path)
 	b.WriteByte('?')
 	var i int
-	for k, v := range query {
-		for _, vv := range v {
+	for _, k := range keys {
+		for _, vv := range query[k] {
 			if i != 0 {
 				b.WriteByte('&')
 			}
@@ -184,5 +535,5 @@ func buildURL(path string, query map[string][]string) (*url.URL, error) {
 			i++
 		}
 	}
-	return url.Parse(b.String())
+	return parse(b.String())
 }
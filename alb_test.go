@@ -2,7 +2,10 @@ package alb
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
@@ -30,12 +33,13 @@ func TestHandler_ReturnsFunction(t *testing.T) {
 
 func TestBuildURL(t *testing.T) {
 	tests := []struct {
-		name      string
-		path      string
-		query     map[string][]string
-		wantPath  string
-		wantQuery url.Values
-		wantErr   bool
+		name         string
+		path         string
+		query        map[string][]string
+		wantPath     string
+		wantQuery    url.Values
+		wantRawQuery string
+		wantErr      bool
 	}{
 		{
 			name:     "path only",
@@ -57,11 +61,12 @@ func TestBuildURL(t *testing.T) {
 			wantQuery: url.Values{"q": {"hello"}},
 		},
 		{
-			name:      "multiple query params",
-			path:      "/filter",
-			query:     map[string][]string{"page": {"1"}, "limit": {"10"}},
-			wantPath:  "/filter",
-			wantQuery: url.Values{"page": {"1"}, "limit": {"10"}},
+			name:         "multiple query params",
+			path:         "/filter",
+			query:        map[string][]string{"page": {"1"}, "limit": {"10"}},
+			wantPath:     "/filter",
+			wantQuery:    url.Values{"page": {"1"}, "limit": {"10"}},
+			wantRawQuery: "limit=10&page=1",
 		},
 		{
 			name:      "encoded query values",
@@ -91,11 +96,12 @@ func TestBuildURL(t *testing.T) {
 			wantQuery: url.Values{"id": {"1", "2", "3"}},
 		},
 		{
-			name:      "mixed single and multi-value params",
-			path:      "/search",
-			query:     map[string][]string{"q": {"test"}, "tags": {"go", "aws"}},
-			wantPath:  "/search",
-			wantQuery: url.Values{"q": {"test"}, "tags": {"go", "aws"}},
+			name:         "mixed single and multi-value params",
+			path:         "/search",
+			query:        map[string][]string{"q": {"test"}, "tags": {"go", "aws"}},
+			wantPath:     "/search",
+			wantQuery:    url.Values{"q": {"test"}, "tags": {"go", "aws"}},
+			wantRawQuery: "q=test&tags=go&tags=aws",
 		},
 	}
 
@@ -117,6 +123,9 @@ func TestBuildURL(t *testing.T) {
 					t.Errorf("buildURL() query = %v, want %v", got.Query(), tt.wantQuery)
 				}
 			}
+			if tt.wantRawQuery != "" && got.RawQuery != tt.wantRawQuery {
+				t.Errorf("buildURL() raw query = %q, want %q", got.RawQuery, tt.wantRawQuery)
+			}
 		})
 	}
 }
@@ -125,7 +134,8 @@ func TestLambdaHandler_Run(t *testing.T) {
 	tests := []struct {
 		name           string
 		handler        http.Handler
-		req            request
+		opts           []Option
+		req            Event
 		wantStatus     int
 		wantBody       string
 		wantBodyBase64 bool
@@ -138,7 +148,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte("hello"))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/test",
 			},
@@ -151,7 +161,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				body, _ := io.ReadAll(r.Body)
 				w.Write(body)
 			}),
-			req: request{
+			req: Event{
 				Method: "POST",
 				Path:   "/echo",
 				Body:   "request body",
@@ -165,7 +175,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				body, _ := io.ReadAll(r.Body)
 				w.Write(body)
 			}),
-			req: request{
+			req: Event{
 				Method:      "POST",
 				Path:        "/echo",
 				Body:        base64.StdEncoding.EncodeToString([]byte("decoded content")),
@@ -179,20 +189,35 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte("should not reach"))
 			}),
-			req: request{
+			opts: []Option{WithErrorClassifier(ClientErrors)},
+			req: Event{
 				Method:      "POST",
 				Path:        "/test",
 				Body:        "not-valid-base64!!!",
 				BodyEncoded: true,
 			},
-			wantErr: true,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
+		},
+		{
+			name: "invalid path percent-encoding",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("should not reach"))
+			}),
+			opts: []Option{WithErrorClassifier(ClientErrors)},
+			req: Event{
+				Method: "GET",
+				Path:   "/test%zz",
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request\n",
 		},
 		{
 			name: "binary response gets base64 encoded",
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte{0x00, 0x01, 0x02, 0xff, 0xfe})
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/binary",
 			},
@@ -205,7 +230,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Header.Get("X-Custom-Header")))
 			}),
-			req: request{
+			req: Event{
 				Method:  "GET",
 				Path:    "/headers",
 				Headers: map[string]string{"X-Custom-Header": "custom-value"},
@@ -218,7 +243,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Host))
 			}),
-			req: request{
+			req: Event{
 				Method:  "GET",
 				Path:    "/host",
 				Headers: map[string]string{"Host": "example.com"},
@@ -231,7 +256,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.URL.Query().Get("key")))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/query",
 				Query:  map[string]string{"key": "value"},
@@ -254,7 +279,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				}
 				w.Write([]byte(strings.Join(parts, "&")))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/query",
 				Query:  map[string]string{"a": "1", "b": "2", "c": "3"},
@@ -268,7 +293,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				w.Header().Set("X-Response-Header", "response-value")
 				w.WriteHeader(http.StatusCreated)
 			}),
-			req: request{
+			req: Event{
 				Method: "POST",
 				Path:   "/create",
 			},
@@ -282,7 +307,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				w.Header().Add("X-Multi", "second")
 				w.WriteHeader(http.StatusOK)
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/multi",
 			},
@@ -295,7 +320,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				w.WriteHeader(http.StatusNotFound)
 				w.Write([]byte("not found"))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/missing",
 			},
@@ -308,7 +333,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte("error"))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/error",
 			},
@@ -320,7 +345,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusNoContent)
 			}),
-			req: request{
+			req: Event{
 				Method: "DELETE",
 				Path:   "/resource",
 			},
@@ -336,7 +361,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				}
 				w.WriteHeader(http.StatusOK)
 			}),
-			req: request{
+			req: Event{
 				Method: "POST",
 				Path:   "/length",
 				Body:   "hello world",
@@ -352,7 +377,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				}
 				w.WriteHeader(http.StatusOK)
 			}),
-			req: request{
+			req: Event{
 				Method:      "POST",
 				Path:        "/length",
 				Body:        base64.StdEncoding.EncodeToString([]byte("content")),
@@ -365,7 +390,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Method))
 			}),
-			req: request{
+			req: Event{
 				Method: "PATCH",
 				Path:   "/update",
 			},
@@ -377,7 +402,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.URL.Path))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/api/v1/users",
 			},
@@ -389,7 +414,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Proto))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/proto",
 			},
@@ -401,7 +426,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte("Hello, 世界! 🌍"))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/utf8",
 			},
@@ -415,7 +440,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				ids := r.URL.Query()["id"]
 				w.Write([]byte(strings.Join(ids, ",")))
 			}),
-			req: request{
+			req: Event{
 				Method:          "GET",
 				Path:            "/filter",
 				MultiValueQuery: map[string][]string{"id": {"1", "2", "3"}},
@@ -429,7 +454,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 				accepts := r.Header.Values("Accept")
 				w.Write([]byte(strings.Join(accepts, ",")))
 			}),
-			req: request{
+			req: Event{
 				Method:            "GET",
 				Path:              "/headers",
 				MultiValueHeaders: map[string][]string{"Accept": {"text/html", "application/json"}},
@@ -442,7 +467,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.URL.Query().Get("key")))
 			}),
-			req: request{
+			req: Event{
 				Method:          "GET",
 				Path:            "/query",
 				Query:           map[string]string{"key": "ignored"},
@@ -456,7 +481,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Header.Get("X-Custom")))
 			}),
-			req: request{
+			req: Event{
 				Method:            "GET",
 				Path:              "/headers",
 				Headers:           map[string]string{"X-Custom": "ignored"},
@@ -470,7 +495,7 @@ func TestLambdaHandler_Run(t *testing.T) {
 			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte(r.Host))
 			}),
-			req: request{
+			req: Event{
 				Method:            "GET",
 				Path:              "/host",
 				MultiValueHeaders: map[string][]string{"Host": {"multi.example.com"}},
@@ -483,6 +508,9 @@ func TestLambdaHandler_Run(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := &lambdaHandler{handler: tt.handler}
+			for _, opt := range tt.opts {
+				opt(h)
+			}
 			got, err := h.Run(context.Background(), tt.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
@@ -509,6 +537,69 @@ func TestLambdaHandler_Run(t *testing.T) {
 	}
 }
 
+func TestLambdaHandler_EventError(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called")
+	})
+	badBody := Event{Method: "POST", Path: "/", Body: "not-valid-base64!!!", BodyEncoded: true}
+	badPath := Event{Method: "GET", Path: "/%zz"}
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		req       Event
+		wantField string
+		wantErr   bool
+	}{
+		{
+			name:      "invalid body fails invocation by default",
+			req:       badBody,
+			wantField: "body",
+			wantErr:   true,
+		},
+		{
+			name:      "invalid path fails invocation by default",
+			req:       badPath,
+			wantField: "path",
+			wantErr:   true,
+		},
+		{
+			name:      "classifier keeps body errors as invocation errors",
+			opts:      []Option{WithErrorClassifier(func(e *EventError) bool { return e.Field != "body" })},
+			req:       badBody,
+			wantField: "body",
+			wantErr:   true,
+		},
+		{
+			name: "classifier answers path errors with 400",
+			opts: []Option{WithErrorClassifier(func(e *EventError) bool { return e.Field != "body" })},
+			req:  badPath,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := Handler(handler, tt.opts...)(context.Background(), tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var e *EventError
+				if !errors.As(err, &e) {
+					t.Fatalf("error %v is not an *EventError", err)
+				}
+				if e.Field != tt.wantField {
+					t.Errorf("EventError.Field = %q, want %q", e.Field, tt.wantField)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
 func TestLambdaHandler_ContextPropagation(t *testing.T) {
 	type ctxKey string
 	key := ctxKey("test-key")
@@ -525,7 +616,7 @@ func TestLambdaHandler_ContextPropagation(t *testing.T) {
 	}
 
 	ctx := context.WithValue(context.Background(), key, "test-value")
-	resp, err := h.Run(ctx, request{Method: "GET", Path: "/"})
+	resp, err := h.Run(ctx, Event{Method: "GET", Path: "/"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -537,6 +628,48 @@ func TestLambdaHandler_ContextPropagation(t *testing.T) {
 	}
 }
 
+func TestEventFromContext(t *testing.T) {
+	const payload = `{
+		"requestContext": {"elb": {"targetGroupArn": "arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/lambda-target/abcdef"}},
+		"httpMethod": "GET",
+		"path": "/lambda",
+		"queryStringParameters": {"query": "1234ABCD"},
+		"headers": {"host": "lambda-alb-123578498.us-east-2.elb.amazonaws.com", "x-custom": "value"},
+		"body": "",
+		"isBase64Encoded": false
+	}`
+	var ev Event
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+		t.Fatal(err)
+	}
+	var got *Event
+	fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		if got, ok = EventFromContext(r.Context()); !ok {
+			t.Error("EventFromContext returned no event")
+		}
+	}))
+	if _, err := fn(context.Background(), ev); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got == nil {
+		t.Fatal("no event captured")
+	}
+	if want := "arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/lambda-target/abcdef"; got.RequestContext.ELB.TargetGroupARN != want {
+		t.Errorf("TargetGroupARN = %q, want %q", got.RequestContext.ELB.TargetGroupARN, want)
+	}
+	if got.Headers["x-custom"] != "value" {
+		t.Errorf("original headers not preserved: %v", got.Headers)
+	}
+	if got.Query["query"] != "1234ABCD" || got.BodyEncoded {
+		t.Errorf("unexpected event: %+v", got)
+	}
+
+	if _, ok := EventFromContext(context.Background()); ok {
+		t.Error("EventFromContext returned event for unrelated context")
+	}
+}
+
 func TestLambdaHandler_ProtoVersion(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -565,7 +698,7 @@ func TestLambdaHandler_ProtoVersion(t *testing.T) {
 				}),
 			}
 
-			_, err := h.Run(context.Background(), request{Method: "GET", Path: "/"})
+			_, err := h.Run(context.Background(), Event{Method: "GET", Path: "/"})
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -580,7 +713,7 @@ func TestHandler_Integration(t *testing.T) {
 	tests := []struct {
 		name       string
 		handler    http.Handler
-		req        request
+		req        Event
 		wantStatus int
 		wantBody   string
 	}{
@@ -591,7 +724,7 @@ func TestHandler_Integration(t *testing.T) {
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte("method=" + r.Method + " path=" + r.URL.Path))
 			}),
-			req: request{
+			req: Event{
 				Method: "GET",
 				Path:   "/api/test",
 			},
@@ -605,7 +738,7 @@ func TestHandler_Integration(t *testing.T) {
 				id := r.URL.Query().Get("id")
 				w.Write([]byte("auth=" + auth + " id=" + id))
 			}),
-			req: request{
+			req: Event{
 				Method:  "GET",
 				Path:    "/secure",
 				Query:   map[string]string{"id": "123"},
@@ -654,7 +787,7 @@ func TestResponse_StatusDescription(t *testing.T) {
 					w.WriteHeader(tt.statusCode)
 				}),
 			}
-			resp, err := h.Run(context.Background(), request{Method: "GET", Path: "/"})
+			resp, err := h.Run(context.Background(), Event{Method: "GET", Path: "/"})
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -768,7 +901,7 @@ func TestLambdaHandler_LargeBody(t *testing.T) {
 				}),
 			}
 
-			_, err := h.Run(context.Background(), request{
+			_, err := h.Run(context.Background(), Event{
 				Method:      "POST",
 				Path:        "/upload",
 				Body:        tt.requestBody,
@@ -781,6 +914,65 @@ func TestLambdaHandler_LargeBody(t *testing.T) {
 	}
 }
 
+// BenchmarkLambdaHandler_LargeBody reports memory allocated to pass near
+// 1 MB base64-encoded bodies through the handler: request body is decoded
+// as the handler reads it, response body is encoded into the response JSON.
+// Decoding and encoding whole bodies up front instead, as done before,
+// allocated 722184 B/op for request, 2878073 for response and 4557404 for
+// round trip, against 1248, 960940 and 1919464 with streaming.
+func BenchmarkLambdaHandler_LargeBody(b *testing.B) {
+	data := make([]byte, 700<<10)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	payload, err := json.Marshal(Event{Method: "POST", Path: "/upload", Body: encoded, BodyEncoded: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("request", func(b *testing.B) {
+		h := &lambdaHandler{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+			}),
+		}
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := h.Run(context.Background(), Event{Method: "POST", Path: "/upload", Body: encoded, BodyEncoded: true}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("response", func(b *testing.B) {
+		inv := NewInvoker(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(data)
+		}))
+		req := []byte(`{"httpMethod":"GET","path":"/download"}`)
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := inv.Invoke(context.Background(), req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("round trip", func(b *testing.B) {
+		inv := NewInvoker(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.Copy(w, r.Body)
+		}))
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if _, err := inv.Invoke(context.Background(), payload); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func TestLambdaHandler_HeaderCanonicalization(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -839,7 +1031,7 @@ func TestLambdaHandler_HeaderCanonicalization(t *testing.T) {
 				}),
 			}
 
-			_, err := h.Run(context.Background(), request{
+			_, err := h.Run(context.Background(), Event{
 				Method:            "GET",
 				Path:              "/",
 				Headers:           tt.headers,
@@ -858,6 +1050,252 @@ func TestLambdaHandler_HeaderCanonicalization(t *testing.T) {
 	}
 }
 
+func TestLambdaHandler_RemoteAddr(t *testing.T) {
+	tests := []struct {
+		name              string
+		hops              int
+		headers           map[string]string
+		multiValueHeaders map[string][]string
+		want              string
+	}{
+		{
+			name: "no forwarding headers",
+			want: "",
+		},
+		{
+			name:    "single client address",
+			headers: map[string]string{"x-forwarded-for": "203.0.113.7", "x-forwarded-port": "443"},
+			want:    "203.0.113.7:443",
+		},
+		{
+			name:    "missing port",
+			headers: map[string]string{"x-forwarded-for": "203.0.113.7"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "invalid port ignored",
+			headers: map[string]string{"x-forwarded-for": "203.0.113.7", "x-forwarded-port": "https"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "ipv6 client",
+			headers: map[string]string{"x-forwarded-for": "2001:db8::1", "x-forwarded-port": "80"},
+			want:    "[2001:db8::1]:80",
+		},
+		{
+			name:    "client port appended by ALB",
+			headers: map[string]string{"x-forwarded-for": "203.0.113.7:51234", "x-forwarded-port": "443"},
+			want:    "203.0.113.7:51234",
+		},
+		{
+			name:    "ipv6 client port appended by ALB",
+			headers: map[string]string{"x-forwarded-for": "[2001:db8::1]:51234", "x-forwarded-port": "443"},
+			want:    "[2001:db8::1]:51234",
+		},
+		{
+			name:    "spoofed entries ignored without trusted hops",
+			headers: map[string]string{"x-forwarded-for": "10.0.0.1, 198.51.100.2, 203.0.113.7", "x-forwarded-port": "443"},
+			want:    "203.0.113.7:443",
+		},
+		{
+			name:    "one trusted hop",
+			hops:    1,
+			headers: map[string]string{"x-forwarded-for": "10.0.0.1, 198.51.100.2, 203.0.113.7", "x-forwarded-port": "443"},
+			want:    "198.51.100.2:443",
+		},
+		{
+			name:    "chain shorter than trusted hops",
+			hops:    3,
+			headers: map[string]string{"x-forwarded-for": "198.51.100.2, 203.0.113.7", "x-forwarded-port": "443"},
+			want:    "198.51.100.2:443",
+		},
+		{
+			name:    "malformed selected entry",
+			hops:    1,
+			headers: map[string]string{"x-forwarded-for": "unknown, 203.0.113.7", "x-forwarded-port": "443"},
+			want:    "",
+		},
+		{
+			name:    "malformed untrusted entries ignored",
+			headers: map[string]string{"x-forwarded-for": "<script>, , 203.0.113.7", "x-forwarded-port": "443"},
+			want:    "203.0.113.7:443",
+		},
+		{
+			name:    "empty chain",
+			headers: map[string]string{"x-forwarded-for": " , ", "x-forwarded-port": "443"},
+			want:    "",
+		},
+		{
+			name: "multi-value header entries combined",
+			hops: 1,
+			multiValueHeaders: map[string][]string{
+				"x-forwarded-for":  {"198.51.100.2", "203.0.113.7"},
+				"x-forwarded-port": {"443"},
+			},
+			want: "198.51.100.2:443",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}), WithTrustedHops(tt.hops))
+
+			_, err := fn(context.Background(), Event{
+				Method:            "GET",
+				Path:              "/",
+				Headers:           tt.headers,
+				MultiValueHeaders: tt.multiValueHeaders,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLambdaHandler_ForwardedProto(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		headers    map[string]string
+		wantURL    string
+		wantTLS    bool
+		wantServer string
+		wantVer    uint16
+		wantCipher uint16
+	}{
+		{
+			name:    "no forwarding headers",
+			headers: map[string]string{"host": "example.com"},
+			wantURL: "/path?a=1",
+		},
+		{
+			name:    "plain http",
+			headers: map[string]string{"host": "example.com", "x-forwarded-proto": "http"},
+			wantURL: "http://example.com/path?a=1",
+		},
+		{
+			name:       "https without ALB TLS headers",
+			headers:    map[string]string{"host": "example.com", "x-forwarded-proto": "https"},
+			wantURL:    "https://example.com/path?a=1",
+			wantTLS:    true,
+			wantServer: "example.com",
+		},
+		{
+			name: "https with TLS 1.2 OpenSSL cipher name",
+			headers: map[string]string{
+				"host":                    "example.com:8443",
+				"x-forwarded-proto":       "HTTPS",
+				"x-amzn-tls-version":      "TLSv1.2",
+				"x-amzn-tls-cipher-suite": "ECDHE-RSA-AES128-GCM-SHA256",
+			},
+			wantURL:    "https://example.com:8443/path?a=1",
+			wantTLS:    true,
+			wantServer: "example.com",
+			wantVer:    tls.VersionTLS12,
+			wantCipher: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			name: "https with TLS 1.3 cipher name",
+			headers: map[string]string{
+				"host":                    "example.com",
+				"x-forwarded-proto":       "https",
+				"x-amzn-tls-version":      "TLSv1.3",
+				"x-amzn-tls-cipher-suite": "TLS_AES_128_GCM_SHA256",
+			},
+			wantURL:    "https://example.com/path?a=1",
+			wantTLS:    true,
+			wantServer: "example.com",
+			wantVer:    tls.VersionTLS13,
+			wantCipher: tls.TLS_AES_128_GCM_SHA256,
+		},
+		{
+			name: "unknown TLS values left zero",
+			headers: map[string]string{
+				"host":                    "example.com",
+				"x-forwarded-proto":       "https",
+				"x-amzn-tls-version":      "SSLv3",
+				"x-amzn-tls-cipher-suite": "NOT-A-CIPHER",
+			},
+			wantURL:    "https://example.com/path?a=1",
+			wantTLS:    true,
+			wantServer: "example.com",
+		},
+		{
+			name:    "https without host keeps relative URL",
+			headers: map[string]string{"x-forwarded-proto": "https"},
+			wantURL: "/path?a=1",
+			wantTLS: true,
+		},
+		{
+			name:    "unexpected proto ignored",
+			headers: map[string]string{"host": "example.com", "x-forwarded-proto": "gopher"},
+			wantURL: "/path?a=1",
+		},
+		{
+			name:       "last proto in list wins",
+			headers:    map[string]string{"host": "example.com", "x-forwarded-proto": "http, https"},
+			wantURL:    "https://example.com/path?a=1",
+			wantTLS:    true,
+			wantServer: "example.com",
+		},
+		{
+			name:    "opted out",
+			opts:    []Option{WithForwardedProto(false)},
+			headers: map[string]string{"host": "example.com", "x-forwarded-proto": "https"},
+			wantURL: "/path?a=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotURL string
+			var gotTLS *tls.ConnectionState
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotURL = r.URL.String()
+				gotTLS = r.TLS
+			}), tt.opts...)
+
+			_, err := fn(context.Background(), Event{
+				Method:  "GET",
+				Path:    "/path",
+				Query:   map[string]string{"a": "1"},
+				Headers: tt.headers,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotURL != tt.wantURL {
+				t.Errorf("URL = %q, want %q", gotURL, tt.wantURL)
+			}
+			if (gotTLS != nil) != tt.wantTLS {
+				t.Fatalf("TLS = %v, want TLS %v", gotTLS, tt.wantTLS)
+			}
+			if gotTLS == nil {
+				return
+			}
+			if !gotTLS.HandshakeComplete {
+				t.Error("TLS.HandshakeComplete = false, want true")
+			}
+			if gotTLS.ServerName != tt.wantServer {
+				t.Errorf("TLS.ServerName = %q, want %q", gotTLS.ServerName, tt.wantServer)
+			}
+			if gotTLS.Version != tt.wantVer {
+				t.Errorf("TLS.Version = %#x, want %#x", gotTLS.Version, tt.wantVer)
+			}
+			if gotTLS.CipherSuite != tt.wantCipher {
+				t.Errorf("TLS.CipherSuite = %#x, want %#x", gotTLS.CipherSuite, tt.wantCipher)
+			}
+		})
+	}
+}
+
 func TestLambdaHandler_EmptyRequest(t *testing.T) {
 	h := &lambdaHandler{
 		handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -865,7 +1303,7 @@ func TestLambdaHandler_EmptyRequest(t *testing.T) {
 		}),
 	}
 
-	resp, err := h.Run(context.Background(), request{})
+	resp, err := h.Run(context.Background(), Event{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -881,7 +1319,7 @@ func TestLambdaHandler_ResponseHeadersInitialized(t *testing.T) {
 		}),
 	}
 
-	resp, err := h.Run(context.Background(), request{Method: "GET", Path: "/"})
+	resp, err := h.Run(context.Background(), Event{Method: "GET", Path: "/"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -932,7 +1370,7 @@ func TestRequest_HeadersProvided(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &request{
+			r := &Event{
 				Headers:           tt.headers,
 				MultiValueHeaders: tt.multiValueHeaders,
 			}
@@ -991,7 +1429,7 @@ func TestRequest_QueryProvided(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &request{
+			r := &Event{
 				Query:           tt.query,
 				MultiValueQuery: tt.multiValueQuery,
 			}
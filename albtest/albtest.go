@@ -0,0 +1,194 @@
+// Package albtest provides http.RoundTripper serving requests with
+// http.Handler wrapped with alb.Handler, so that handlers can be tested with
+// http.Client the way they are reached behind ALB:
+//
+//	client := albtest.NewClient(handler, albtest.WithHeaderMode(alb.MultiValueHeaders))
+//	resp, err := client.Get("https://example.com/hello")
+//
+// Every request is served in-process by the handler returned by alb.Emulate,
+// which converts it to ALB event JSON, passes the event to the function
+// returned by alb.Handler as github.com/aws/aws-lambda-go does, and writes
+// its response JSON back.
+package albtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/MichaelFraser99/alb"
+	"github.com/MichaelFraser99/alb/internal/emulation"
+)
+
+// DefaultClientAddr is the client address set in X-Forwarded-For header by
+// default.
+const DefaultClientAddr = "192.0.2.1"
+
+// Exchange is an invocation of the handler made by Transport.
+type Exchange struct {
+	Event    []byte // event JSON
+	Response []byte // response JSON, nil if the invocation failed
+	Err      error  // invocation error
+}
+
+// Transport is http.RoundTripper invoking the handler in-process with ALB
+// events. Requests are served by the handler returned by alb.Emulate, so
+// that bodies over 1 MB are answered with 413 Request Entity Too Large, and
+// failed invocations or responses over 1 MB with 502 Bad Gateway.
+type Transport struct {
+	h           http.Handler
+	mode        alb.HeaderMode
+	header      http.Header
+	observer    func(*Exchange)
+	handlerOpts []alb.Option
+}
+
+// Option configures Transport.
+type Option func(*Transport)
+
+// WithHeaderMode sets header mode of the emulated target group, events are
+// built in its shape. The default is single-value headers. The mode is also
+// declared to the handler with alb.WithHeaderMode.
+func WithHeaderMode(m alb.HeaderMode) Option {
+	return func(t *Transport) { t.mode = m }
+}
+
+// WithHeader sets request header added to every event, as ALB does with
+// X-Amzn-Oidc-* or X-Amzn-Mtls-* headers. Empty value removes the header,
+// including the ones added by default: X-Forwarded-For with
+// DefaultClientAddr appended, X-Forwarded-Proto and X-Forwarded-Port
+// matching request URL, and X-Amzn-Trace-Id.
+func WithHeader(key, value string) Option {
+	return func(t *Transport) { t.header.Set(key, value) }
+}
+
+// WithHandlerOptions sets options the handler is wrapped with, as passed
+// to alb.Handler.
+func WithHandlerOptions(opts ...alb.Option) Option {
+	return func(t *Transport) { t.handlerOpts = append(t.handlerOpts, opts...) }
+}
+
+// WithObserver sets function called with every exchange made, so that
+// tests can inspect the raw event and response JSON.
+func WithObserver(f func(*Exchange)) Option {
+	return func(t *Transport) { t.observer = f }
+}
+
+// NewTransport returns Transport serving requests with h wrapped with
+// alb.Handler.
+func NewTransport(h http.Handler, opts ...Option) *Transport {
+	t := &Transport{mode: alb.SingleValueHeaders, header: make(http.Header)}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.mode == alb.DetectHeaders {
+		t.mode = alb.SingleValueHeaders
+	}
+	t.h = alb.Emulate(h, append([]alb.Option{alb.WithHeaderMode(t.mode)}, t.handlerOpts...)...)
+	return t
+}
+
+// NewClient returns http.Client using Transport returned by NewTransport.
+// Redirects are followed by the client as usual, each of them is another
+// invocation of the handler.
+func NewClient(h http.Handler, opts ...Option) *http.Client {
+	return &http.Client{Transport: NewTransport(h, opts...)}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	// give the request the form it has on the server side of a connection
+	// from DefaultClientAddr
+	port := req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	n, _ := strconv.Atoi(port)
+	ctx := context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{Port: n})
+	ctx = emulation.WithTrace(ctx, &emulation.Trace{
+		Forwarded: t.setHeaders,
+		Invoked: func(event, response []byte, err error) {
+			if t.observer != nil {
+				t.observer(&Exchange{Event: event, Response: response, Err: err})
+			}
+		},
+	})
+	r := req.Clone(ctx)
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	if r.Host == "" {
+		r.Host = r.URL.Host
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.RemoteAddr = net.JoinHostPort(DefaultClientAddr, "1024")
+	if r.URL.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{ServerName: r.URL.Hostname()}
+	}
+	w := &recorder{header: make(http.Header)}
+	t.h.ServeHTTP(w, r)
+	return w.response(req), nil
+}
+
+// recorder is http.ResponseWriter collecting the response of the emulator.
+// It is used instead of httptest.ResponseRecorder to keep package testing
+// out of importers.
+type recorder struct {
+	header  http.Header
+	code    int
+	written http.Header // header as of WriteHeader call
+	body    bytes.Buffer
+}
+
+func (w *recorder) Header() http.Header { return w.header }
+
+func (w *recorder) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code, w.written = code, w.header.Clone()
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// response returns the response to req as the client receives it.
+func (w *recorder) response(req *http.Request) *http.Response {
+	w.WriteHeader(http.StatusOK)
+	return &http.Response{
+		Status:        strconv.Itoa(w.code) + " " + http.StatusText(w.code),
+		StatusCode:    w.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.written,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}
+}
+
+// setHeaders applies headers set with WithHeader.
+func (t *Transport) setHeaders(h http.Header) {
+	for k, vv := range t.header {
+		if vv[0] == "" {
+			h.Del(k)
+			continue
+		}
+		h[k] = vv
+	}
+}
@@ -0,0 +1,191 @@
+package albtest
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/alb"
+	"github.com/MichaelFraser99/alb/internal/emulation"
+)
+
+func TestClient(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		case "/new":
+			http.SetCookie(w, &http.Cookie{Name: "a", Value: "1"})
+			http.SetCookie(w, &http.Cookie{Name: "b", Value: "2"})
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"remote":   r.RemoteAddr,
+				"url":      r.URL.String(),
+				"accept":   r.Header.Values("Accept"),
+				"identity": r.Header.Get("X-Amzn-Oidc-Identity"),
+			})
+		}
+	})
+	for _, mode := range []alb.HeaderMode{alb.SingleValueHeaders, alb.MultiValueHeaders} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var exchanges []*Exchange
+			client := NewClient(handler,
+				WithHeaderMode(mode),
+				WithHeader("X-Amzn-Oidc-Identity", "user"),
+				WithObserver(func(x *Exchange) { exchanges = append(exchanges, x) }),
+			)
+			req, err := http.NewRequest("GET", "https://example.com/old?q=a%20b", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add("Accept", "text/html")
+			req.Header.Add("Accept", "*/*")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var got struct {
+				Remote, URL, Identity string
+				Accept                []string
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/new" {
+				t.Errorf("got %s for %s, want 200 for /new", resp.Status, resp.Request.URL)
+			}
+			if got := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+				t.Errorf("Set-Cookie = %q", got)
+			}
+			wantAccept := []string{"*/*"}
+			if mode == alb.MultiValueHeaders {
+				wantAccept = []string{"text/html", "*/*"}
+			}
+			if got.Remote != DefaultClientAddr+":443" || got.URL != "https://example.com/new" ||
+				got.Identity != "user" || !reflect.DeepEqual(got.Accept, wantAccept) {
+				t.Errorf("handler got %+v", got)
+			}
+
+			if len(exchanges) != 2 {
+				t.Fatalf("observed %d exchanges, want 2", len(exchanges))
+			}
+			var ev alb.Event
+			if err := json.Unmarshal(exchanges[0].Event, &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.Path != "/old" || ev.QueryProvided()["q"][0] != "a%20b" || ev.RequestContext.ELB.TargetGroupARN != emulation.TargetGroupARN {
+				t.Errorf("unexpected event: %s", exchanges[0].Event)
+			}
+			headers := ev.HeadersProvided()
+			for k, want := range map[string]string{
+				"host":                 "example.com",
+				"x-forwarded-for":      DefaultClientAddr,
+				"x-forwarded-proto":    "https",
+				"x-forwarded-port":     "443",
+				"x-amzn-oidc-identity": "user",
+			} {
+				if got := headers[k]; len(got) != 1 || got[0] != want {
+					t.Errorf("event header %s = %q, want %q", k, got, want)
+				}
+			}
+			key := `"headers":{`
+			if mode == alb.MultiValueHeaders {
+				key = `"multiValueHeaders":{`
+			}
+			if !strings.Contains(string(exchanges[1].Response), key) {
+				t.Errorf("response JSON does not contain %s: %s", key, exchanges[1].Response)
+			}
+		})
+	}
+}
+
+func TestClient_RemoveHeader(t *testing.T) {
+	var header http.Header
+	client := NewClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}), WithHeader("X-Amzn-Trace-Id", ""), WithHeader("X-Forwarded-For", "198.51.100.1"))
+	req, _ := http.NewRequest("POST", "http://example.com:8080/", strings.NewReader("body"))
+	req.Header.Set("X-Forwarded-For", "203.0.113.1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, ok := header["X-Amzn-Trace-Id"]; ok {
+		t.Error("X-Amzn-Trace-Id was not removed")
+	}
+	want := http.Header{
+		"Host":              {"example.com:8080"},
+		"Content-Length":    {"4"},
+		"X-Forwarded-For":   {"198.51.100.1"},
+		"X-Forwarded-Proto": {"http"},
+		"X-Forwarded-Port":  {"8080"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("handler got header %v, want %v", header, want)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []alb.Option
+		handler    http.HandlerFunc
+		body       string
+		wantStatus int
+		wantErr    bool
+	}{
+		{
+			name:       "request body too large",
+			handler:    func(w http.ResponseWriter, r *http.Request) { t.Error("handler called") },
+			body:       strings.Repeat("x", 1<<20+1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "invocation error",
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			wantStatus: http.StatusBadGateway,
+			wantErr:    true,
+		},
+		{
+			name: "response too large",
+			opts: []alb.Option{alb.WithResponseLimit(0)},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, strings.Repeat("x", alb.DefaultResponseLimit))
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "handler options applied",
+			opts: []alb.Option{alb.WithPanicHandler(alb.JSONPanicHandler)},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var x *Exchange
+			opts := append([]alb.Option{alb.WithLogger(log.New(io.Discard, "", 0))}, tt.opts...)
+			client := NewClient(tt.handler, WithHandlerOptions(opts...), WithObserver(func(e *Exchange) { x = e }))
+			resp, err := client.Post("http://example.com/", "text/plain", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if gotErr := x != nil && x.Err != nil; gotErr != tt.wantErr {
+				t.Errorf("exchange error reported = %v, want %v", gotErr, tt.wantErr)
+			}
+		})
+	}
+}
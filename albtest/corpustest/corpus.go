@@ -0,0 +1,114 @@
+// Package corpustest checks handlers against a corpus of ALB events with
+// the responses recorded for them. It is kept apart from package albtest,
+// as it is only meant to be imported by tests.
+package corpustest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/alb"
+)
+
+// Corpus is a directory tree of ALB events with the responses
+// expected for them. Every event is stored in a file named NAME.event.json.
+// Responses are serialized differently by alb.Invoker and by encoding/json
+// applied to the result of the function from alb.Handler, so the expected
+// response JSON of each is stored next to the event, in NAME.response.json
+// and NAME.handler.response.json respectively.
+type Corpus struct {
+	Dir string
+
+	// Update makes Run write responses returned by the handler as the
+	// expected ones instead of comparing them, to record responses for new
+	// events or accept changes in behaviour after reviewing them.
+	Update bool
+}
+
+// Run serves every event of the corpus with handler wrapped with
+// alb.NewInvoker(h, opts...) and alb.Handler(h, opts...), in subtests named
+// after the event file and the path. It checks that the response JSON is
+// byte-for-byte the expected one, ignoring a trailing newline of the file.
+// The Invoker path decodes events and encodes responses as done by
+// alb.Start, the Handler path passes them through encoding/json as done by
+// github.com/aws/aws-lambda-go.
+func (c Corpus) Run(t *testing.T, h http.Handler, opts ...alb.Option) {
+	t.Helper()
+	inv := alb.NewInvoker(h, opts...)
+	fn := alb.Handler(h, opts...)
+	var events []string
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, ".event.json") {
+			events = append(events, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatalf("no *.event.json files found in %s", c.Dir)
+	}
+	for _, path := range events {
+		path := path
+		base := strings.TrimSuffix(path, ".event.json")
+		name, err := filepath.Rel(c.Dir, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			payload, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Run("Invoker", func(t *testing.T) {
+				got, err := inv.Invoke(context.Background(), payload)
+				if err != nil {
+					t.Fatalf("invocation failed: %v", err)
+				}
+				c.check(t, base+".response.json", got)
+			})
+			t.Run("Handler", func(t *testing.T) {
+				var ev alb.Event
+				if err := json.Unmarshal(payload, &ev); err != nil {
+					t.Fatal(err)
+				}
+				resp, err := fn(context.Background(), ev)
+				if err != nil {
+					t.Fatalf("invocation failed: %v", err)
+				}
+				got, err := json.Marshal(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.check(t, base+".handler.response.json", got)
+			})
+		})
+	}
+}
+
+// check compares response JSON with the one in golden file, or records it
+// if c.Update is set.
+func (c Corpus) check(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	if c.Update {
+		if err := os.WriteFile(golden, append(got, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v; run with Corpus.Update set to record the response", err)
+	}
+	if want = bytes.TrimSuffix(want, []byte("\n")); !bytes.Equal(got, want) {
+		t.Errorf("response differs from %s\n got: %s\nwant: %s", golden, got, want)
+	}
+}
@@ -0,0 +1,47 @@
+package corpustest
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "multi"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]string{
+		"hello.event.json":       `{"httpMethod":"GET","path":"/hello","headers":{}}`,
+		"multi/hello.event.json": `{"httpMethod":"GET","path":"/hello","multiValueHeaders":{}}`,
+	}
+	for name, ev := range events {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(ev), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "<"+r.URL.Path+">")
+	})
+
+	Corpus{Dir: dir, Update: true}.Run(t, handler)
+	want := map[string]string{
+		"hello.response.json":               `{"statusCode":200,"statusDescription":"200 OK","headers":{"Content-Type":"text/plain"},"multiValueHeaders":null,"body":"</hello>","isBase64Encoded":false}` + "\n",
+		"multi/hello.response.json":         `{"statusCode":200,"statusDescription":"200 OK","headers":null,"multiValueHeaders":{"Content-Type":["text/plain"]},"body":"</hello>","isBase64Encoded":false}` + "\n",
+		"hello.handler.response.json":       `{"statusCode":200,"statusDescription":"200 OK","headers":{"Content-Type":"text/plain"},"multiValueHeaders":null,"body":"\u003c/hello\u003e","isBase64Encoded":false}` + "\n",
+		"multi/hello.handler.response.json": `{"statusCode":200,"statusDescription":"200 OK","headers":null,"multiValueHeaders":{"Content-Type":["text/plain"]},"body":"\u003c/hello\u003e","isBase64Encoded":false}` + "\n",
+	}
+	for name, want := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\n got: %s\nwant: %s", name, got, want)
+		}
+	}
+	Corpus{Dir: dir}.Run(t, handler)
+}
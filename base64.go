@@ -0,0 +1,117 @@
+package alb
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+)
+
+// base64Body returns reader decoding base64-encoded body s as it is read,
+// and the decoded size. Malformed body is reported up front.
+func base64Body(s string) (io.Reader, int64, error) {
+	if n, ok := base64Size(s); ok {
+		return &base64Reader{s: s}, n, nil
+	}
+	// either malformed, or wrapped into lines, which ALB does not do; let
+	// encoding/base64 sort it out
+	n, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(s)))
+	if err != nil {
+		return nil, 0, err
+	}
+	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(s)), n, nil
+}
+
+// base64Size returns size of s decoded, if it is padded base64 without line
+// breaks that base64Reader can decode.
+func base64Size(s string) (int64, bool) {
+	if len(s)%4 != 0 {
+		return 0, false
+	}
+	data := strings.TrimRight(s, "=")
+	pad := len(s) - len(data)
+	if pad > 2 {
+		return 0, false
+	}
+	for i := 0; i < len(data); i++ {
+		if base64Values[data[i]] == 0xff {
+			return 0, false
+		}
+	}
+	return int64(len(s)/4*3 - pad), true
+}
+
+var base64Values = func() (t [256]byte) {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	for i := range t {
+		t[i] = 0xff
+	}
+	for i := 0; i < len(alphabet); i++ {
+		t[alphabet[i]] = byte(i)
+	}
+	return t
+}()
+
+// base64Reader decodes padded base64 string validated with base64Size
+// without copying it.
+type base64Reader struct {
+	s       string // remaining encoded data
+	pending [3]byte
+	npend   int
+	off     int // offset of the first pending byte not returned yet
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	n := 0
+	if r.off < r.npend {
+		n = copy(p, r.pending[r.off:r.npend])
+		r.off += n
+		if n == len(p) {
+			return n, nil
+		}
+	}
+	if len(r.s) == 0 {
+		return n, io.EOF
+	}
+	// all but the last quantum decode to 3 bytes
+	k := (len(p) - n) / 3
+	if full := len(r.s)/4 - 1; k > full {
+		k = full
+	}
+	s, dst := r.s[:k*4], p[n:n+k*3]
+	for i, j := 0, 0; i < len(s); i, j = i+4, j+3 {
+		v := uint(base64Values[s[i]])<<18 | uint(base64Values[s[i+1]])<<12 |
+			uint(base64Values[s[i+2]])<<6 | uint(base64Values[s[i+3]])
+		dst[j], dst[j+1], dst[j+2] = byte(v>>16), byte(v>>8), byte(v)
+	}
+	n += k * 3
+	r.s = r.s[k*4:]
+	if len(r.s) == 4 && len(p)-n >= 3 {
+		n += decodeQuantum(p[n:], r.s)
+		r.s = ""
+	}
+	if len(r.s) != 0 && n < len(p) {
+		r.npend = decodeQuantum(r.pending[:], r.s[:4])
+		r.s = r.s[4:]
+		r.off = copy(p[n:], r.pending[:r.npend])
+		n += r.off
+	}
+	return n, nil
+}
+
+// decodeQuantum decodes 4 base64 characters into dst, returning the number
+// of bytes written.
+func decodeQuantum(dst []byte, q string) int {
+	v := uint(base64Values[q[0]])<<18 | uint(base64Values[q[1]])<<12
+	dst[0] = byte(v >> 16)
+	if q[2] == '=' {
+		return 1
+	}
+	v |= uint(base64Values[q[2]]) << 6
+	dst[1] = byte(v >> 8)
+	if q[3] == '=' {
+		return 2
+	}
+	v |= uint(base64Values[q[3]])
+	dst[2] = byte(v)
+	return 3
+}
@@ -0,0 +1,72 @@
+package alb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestBase64Body(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var inputs []string
+	for n := 0; n < 50; n++ {
+		b := make([]byte, n)
+		rnd.Read(b)
+		inputs = append(inputs, base64.StdEncoding.EncodeToString(b))
+	}
+	inputs = append(inputs,
+		"aGVs\nbG8=",       // line breaks are skipped by encoding/base64
+		"aGVsbG8=\r\n",     // trailing line break
+		"aGVsbG9=",         // non-zero trailing bits are tolerated
+		"aGVsbG8",          // missing padding
+		"aGVsbG8==",        // extra padding
+		"aGV=bG8=",         // padding in the middle
+		"aG==",             // two padding characters
+		"a===",             // too much padding
+		"====",             // padding only
+		"aGVs bG8=",        // space
+		"aGVsbG8_",         // URL alphabet
+		"aGVsbG8=aGVs",     // data after padding
+		"\xffGVsbG8=",      // non-ASCII
+		"aGVsbG8=\x00\x00", // NUL bytes
+	)
+	for _, s := range inputs {
+		want, wantErr := base64.StdEncoding.DecodeString(s)
+		for _, chunk := range []int{1, 2, 3, 4, 5, 7, 512} {
+			body, n, err := base64Body(s)
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("base64Body(%q) error = %v, want %v", s, err, wantErr)
+			}
+			if err != nil {
+				break
+			}
+			if n != int64(len(want)) {
+				t.Errorf("base64Body(%q) size = %d, want %d", s, n, len(want))
+			}
+			got, err := readChunks(body, chunk)
+			if err != nil {
+				t.Fatalf("reading %q in %d byte chunks: %v", s, chunk, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("reading %q in %d byte chunks: got %x, want %x", s, chunk, got, want)
+			}
+		}
+	}
+}
+
+func readChunks(r io.Reader, size int) ([]byte, error) {
+	var out []byte
+	buf := make([]byte, size)
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package alb
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/textproto"
+)
+
+// BlobStore stores response bodies too large to pass through ALB, such as in
+// S3 bucket.
+type BlobStore interface {
+	// Store saves size bytes read from body along with header fields
+	// describing it (Content-Type, Content-Disposition, etc.), and returns
+	// URL the client can fetch it from, such as presigned S3 URL. Body must
+	// not be retained after Store returns.
+	Store(ctx context.Context, header http.Header, body io.Reader, size int64) (url string, err error)
+}
+
+// WithBlobStore makes successful responses exceeding the limit set with
+// WithResponseLimit uploaded to store, with the client redirected to the
+// stored copy with given 3xx status code, http.StatusSeeOther if zero. The
+// redirect keeps headers set by the handler, except for those describing the
+// body, which are stored along with it.
+//
+// Only responses with 200 OK status are stored, others are handled as
+// described in WithResponseLimit, as is a response that failed to store.
+// Note that responses are no longer discarded as soon as they exceed the
+// limit, but are kept in memory in full.
+func WithBlobStore(store BlobStore, code int) Option {
+	return func(h *lambdaHandler) {
+		if code == 0 {
+			code = http.StatusSeeOther
+		}
+		h.blobStore, h.blobRedirect = store, code
+	}
+}
+
+// blobHeaders lists headers stored along with the response body.
+var blobHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+}
+
+// offload stores body of the recorded response converted to out and returns
+// redirect to it.
+func (h *lambdaHandler) offload(req *Event, r *http.Request, w *responseWriter, out *Response) (*Response, error) {
+	_, written := w.finish()
+	header := make(http.Header, len(blobHeaders))
+	for _, k := range blobHeaders {
+		if vv, ok := written[k]; ok {
+			header[k] = vv
+		}
+	}
+	body, size := out.body()
+	url, err := h.blobStore.Store(r.Context(), header, body, size)
+	if err != nil {
+		h.logf("alb: storing response to %s %s: %v", r.Method, r.URL, err)
+		return nil, err
+	}
+	redirect := h.newResponseWriter()
+	defer redirect.release()
+	for k, vv := range written {
+		if k = textproto.CanonicalMIMEHeaderKey(k); k == "Content-Length" || header[k] != nil {
+			continue
+		}
+		redirect.Header()[k] = vv
+	}
+	// stored copy URL is usually short-lived
+	redirect.Header().Set("Cache-Control", "no-store")
+	http.Redirect(redirect, r, url, h.blobRedirect)
+	return h.respond(req, redirect), nil
+}
@@ -0,0 +1,165 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// dirStore is a BlobStore saving blobs to a local directory.
+type dirStore struct {
+	dir string
+	n   int32
+}
+
+func (s *dirStore) Store(ctx context.Context, header http.Header, body io.Reader, size int64) (string, error) {
+	name := strconv.Itoa(int(atomic.AddInt32(&s.n, 1)))
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(b)) != size {
+		return "", errors.New("size mismatch")
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, name), b, 0o644); err != nil {
+		return "", err
+	}
+	meta, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, name+".header"), meta, 0o644); err != nil {
+		return "", err
+	}
+	return "https://blobs.example.com/" + name + "?signature=x", nil
+}
+
+type failingStore struct{}
+
+func (failingStore) Store(context.Context, http.Header, io.Reader, int64) (string, error) {
+	return "", errors.New("store unavailable")
+}
+
+func TestLambdaHandler_BlobStore(t *testing.T) {
+	large := strings.Repeat("a,b,c\n", 200)
+	tests := []struct {
+		name         string
+		store        BlobStore
+		code         int
+		status       int
+		body         string
+		wantStatus   int
+		wantLocation string
+		wantStored   bool
+	}{
+		{
+			name:       "small response not stored",
+			body:       "a,b,c\n",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "large response stored",
+			body:         large,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "https://blobs.example.com/1?signature=x",
+			wantStored:   true,
+		},
+		{
+			name:         "custom redirect code",
+			code:         http.StatusFound,
+			body:         large,
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://blobs.example.com/1?signature=x",
+			wantStored:   true,
+		},
+		{
+			name:       "error response not stored",
+			status:     http.StatusNotFound,
+			body:       large,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "store failure",
+			store:      failingStore{},
+			body:       large,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := tt.store
+			if store == nil {
+				store = &dirStore{dir: dir}
+			}
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/csv")
+				w.Header().Set("Content-Disposition", `attachment; filename="report.csv"`)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.Header().Set("Set-Cookie", "seen=1")
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				io.WriteString(w, tt.body)
+			}), WithResponseLimit(1000), WithBlobStore(store, tt.code), WithLogger(log.New(io.Discard, "", 0)))
+
+			resp, err := fn(context.Background(), Event{Method: "GET", Path: "/report"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Headers["Location"]; got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if !tt.wantStored {
+				return
+			}
+			if got := resp.Headers["Set-Cookie"]; got != "seen=1" {
+				t.Errorf("Set-Cookie = %q, want handler header kept", got)
+			}
+			if got := resp.Headers["Cache-Control"]; got != "no-store" {
+				t.Errorf("Cache-Control = %q, want %q", got, "no-store")
+			}
+			for _, k := range []string{"Content-Disposition", "Content-Length"} {
+				if _, ok := resp.Headers[k]; ok {
+					t.Errorf("redirect has %s header", k)
+				}
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, []byte(large)) {
+				t.Error("stored body differs from response body")
+			}
+			meta, err := os.ReadFile(filepath.Join(dir, "1.header"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var header http.Header
+			if err := json.Unmarshal(meta, &header); err != nil {
+				t.Fatal(err)
+			}
+			want := http.Header{
+				"Content-Type":        {"text/csv"},
+				"Content-Disposition": {`attachment; filename="report.csv"`},
+			}
+			if !reflect.DeepEqual(header, want) {
+				t.Errorf("stored header = %v, want %v", header, want)
+			}
+		})
+	}
+}
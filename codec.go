@@ -0,0 +1,825 @@
+package alb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// decodeEvent decodes ALB event JSON into ev the same way encoding/json
+// does, but without reflection and with fewer allocations: every string kept
+// is allocated once, at its decoded size, or shared with previous events, and
+// others are not allocated.
+func decodeEvent(data []byte, ev *Event) error {
+	d := decoderPool.Get().(*jsonDecoder)
+	d.data, d.pos, d.depth = data, 0, 0
+	defer d.release()
+	if err := d.event(ev); err != nil {
+		return err
+	}
+	d.skipSpace()
+	if d.pos != len(d.data) {
+		return d.syntaxError("after top-level value")
+	}
+	return nil
+}
+
+var decoderPool = sync.Pool{New: func() interface{} { return new(jsonDecoder) }}
+
+// maxPooledMembers is the number of object members scratch space of pooled
+// decoders is limited to.
+const maxPooledMembers = 256
+
+func (d *jsonDecoder) release() {
+	if cap(d.keys) > maxPooledMembers || cap(d.values) > maxPooledMembers || cap(d.lists) > maxPooledMembers {
+		return
+	}
+	// do not keep event strings alive, they are retained by the scratch
+	// space up to its capacity if decoding failed midway
+	clear(d.keys[:cap(d.keys)])
+	clear(d.values[:cap(d.values)])
+	clear(d.lists[:cap(d.lists)])
+	d.data = nil
+	decoderPool.Put(d)
+}
+
+// maxJSONDepth limits nesting of skipped values, as encoding/json does.
+const maxJSONDepth = 10000
+
+type jsonDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+
+	// scratch space to collect object members in, reused across objects
+	// and, via decoderPool, across events
+	keys   []string
+	values []string
+	lists  [][]string
+
+	strs stringCache
+}
+
+// stringCache holds short strings decoded from previous events, so that
+// values repeating across events, such as method or most of the headers,
+// are allocated once. encoding/json does the same.
+type stringCache [256]string
+
+// maxCachedString is the length of strings too long to keep in stringCache.
+const maxCachedString = 16
+
+// make returns b as string, taking it from the cache if possible.
+func (c *stringCache) make(b []byte) string {
+	if len(b) > maxCachedString {
+		return string(b)
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, x := range b {
+		h = (h ^ uint32(x)) * 16777619
+	}
+	e := &c[h%uint32(len(c))]
+	if *e != string(b) {
+		*e = string(b)
+	}
+	return *e
+}
+
+func (d *jsonDecoder) syntaxError(context string) error {
+	if d.pos >= len(d.data) {
+		return errors.New("unexpected end of JSON input")
+	}
+	return fmt.Errorf("invalid character %q %s at offset %d", d.data[d.pos], context, d.pos)
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek skips whitespace and returns the next byte, or 0 at the end of data.
+func (d *jsonDecoder) peek() byte {
+	d.skipSpace()
+	if d.pos < len(d.data) {
+		return d.data[d.pos]
+	}
+	return 0
+}
+
+// literal consumes s if data continues with it.
+func (d *jsonDecoder) literal(s string) bool {
+	d.skipSpace()
+	if len(d.data)-d.pos < len(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if d.data[d.pos+i] != s[i] {
+			return false
+		}
+	}
+	d.pos += len(s)
+	return true
+}
+
+// object consumes the beginning of JSON object, reporting whether it is
+// null instead. Members of non-null object are then read with key.
+func (d *jsonDecoder) object() (null bool, err error) {
+	if d.literal("null") {
+		return true, nil
+	}
+	if d.peek() != '{' {
+		return false, d.syntaxError("looking for beginning of object")
+	}
+	d.pos++
+	return false, nil
+}
+
+// key consumes the next object key and the colon after it, the caller must
+// consume the value then. At the end of object it consumes the closing
+// brace and reports !ok. The key is only valid until the value is decoded.
+func (d *jsonDecoder) key() (key []byte, ok bool, err error) {
+	// values never end with '{', so it is the opening brace of object with
+	// no members read yet
+	first := d.data[d.pos-1] == '{'
+	switch c := d.peek(); {
+	case c == '}':
+		d.pos++
+		return nil, false, nil
+	case !first && c == ',':
+		d.pos++
+	case !first:
+		return nil, false, d.syntaxError("after object key:value pair")
+	}
+	if d.peek() != '"' {
+		return nil, false, d.syntaxError("looking for beginning of object key string")
+	}
+	if key, err = d.rawStr(); err != nil {
+		return nil, false, err
+	}
+	if d.peek() != ':' {
+		return nil, false, d.syntaxError("after object key")
+	}
+	d.pos++
+	return key, true, nil
+}
+
+func (d *jsonDecoder) event(ev *Event) error {
+	if null, err := d.object(); null || err != nil {
+		return err
+	}
+	for {
+		key, ok, err := d.key()
+		if !ok || err != nil {
+			return err
+		}
+		switch eventField(key) {
+		case "requestContext":
+			err = d.requestContext(&ev.RequestContext)
+		case "httpMethod":
+			err = d.stringValue(&ev.Method)
+		case "path":
+			err = d.stringValue(&ev.Path)
+		case "queryStringParameters":
+			err = d.stringMap(&ev.Query)
+		case "multiValueQueryStringParameters":
+			err = d.stringsMap(&ev.MultiValueQuery)
+		case "headers":
+			err = d.stringMap(&ev.Headers)
+		case "multiValueHeaders":
+			err = d.stringsMap(&ev.MultiValueHeaders)
+		case "body":
+			err = d.stringValue(&ev.Body)
+		case "isBase64Encoded":
+			err = d.boolValue(&ev.BodyEncoded)
+		default:
+			err = d.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var eventFields = []string{
+	"requestContext", "httpMethod", "path",
+	"queryStringParameters", "multiValueQueryStringParameters",
+	"headers", "multiValueHeaders", "body", "isBase64Encoded",
+}
+
+// eventField returns Event field name key refers to, matching it case
+// insensitively as encoding/json does.
+func eventField(key []byte) string {
+	for _, f := range eventFields {
+		if string(key) == f {
+			return f
+		}
+	}
+	for _, f := range eventFields {
+		if bytes.EqualFold(key, []byte(f)) {
+			return f
+		}
+	}
+	return ""
+}
+
+func (d *jsonDecoder) requestContext(rc *RequestContext) error {
+	if null, err := d.object(); null || err != nil {
+		return err
+	}
+	for {
+		found, err := d.seek("elb")
+		if !found || err != nil {
+			return err
+		}
+		if null, err := d.object(); err != nil {
+			return err
+		} else if null {
+			continue
+		}
+		for {
+			found, err := d.seek("targetGroupArn")
+			if err != nil {
+				return err
+			}
+			if !found {
+				break
+			}
+			if err := d.stringValue(&rc.ELB.TargetGroupARN); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seek skips object members up to the one named name, matched case
+// insensitively, and reports whether it was found. If so, the caller must
+// consume its value, otherwise the object is consumed.
+func (d *jsonDecoder) seek(name string) (found bool, err error) {
+	for {
+		key, ok, err := d.key()
+		if !ok || err != nil {
+			return false, err
+		}
+		if bytes.EqualFold(key, []byte(name)) {
+			return true, nil
+		}
+		if err := d.skip(); err != nil {
+			return false, err
+		}
+	}
+}
+
+// stringValue decodes JSON string into s, leaving it intact if the value is
+// null.
+func (d *jsonDecoder) stringValue(s *string) error {
+	if d.literal("null") {
+		return nil
+	}
+	if d.peek() != '"' {
+		return d.typeError("string")
+	}
+	v, err := d.str()
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func (d *jsonDecoder) boolValue(b *bool) error {
+	switch {
+	case d.literal("null"):
+	case d.literal("true"):
+		*b = true
+	case d.literal("false"):
+		*b = false
+	default:
+		return d.typeError("bool")
+	}
+	return nil
+}
+
+func (d *jsonDecoder) stringMap(m *map[string]string) error {
+	null, err := d.object()
+	if null || err != nil {
+		*m = nil
+		return err
+	}
+	// members are collected first to allocate map of the right size
+	keys, values := d.keys[:0], d.values[:0]
+	for {
+		key, ok, err := d.key()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, d.internKey(key))
+		var v string
+		if err := d.stringValue(&v); err != nil {
+			return err
+		}
+		values = append(values, v)
+	}
+	if *m == nil {
+		*m = make(map[string]string, len(keys))
+	}
+	for i, k := range keys {
+		(*m)[k] = values[i]
+	}
+	d.keys, d.values = keys, values
+	return nil
+}
+
+func (d *jsonDecoder) stringsMap(m *map[string][]string) error {
+	null, err := d.object()
+	if null || err != nil {
+		*m = nil
+		return err
+	}
+	keys, lists := d.keys[:0], d.lists[:0]
+	for {
+		key, ok, err := d.key()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		keys = append(keys, d.internKey(key))
+		vv, err := d.strings()
+		if err != nil {
+			return err
+		}
+		lists = append(lists, vv)
+	}
+	if *m == nil {
+		*m = make(map[string][]string, len(keys))
+	}
+	for i, k := range keys {
+		(*m)[k] = lists[i]
+	}
+	d.keys, d.lists = keys, lists
+	return nil
+}
+
+// knownKeys holds header names ALB commonly passes in events, as it does:
+// in lower case.
+var knownKeys = make(map[string]string)
+
+func init() {
+	for _, k := range []string{
+		"accept", "accept-encoding", "accept-language", "authorization",
+		"cache-control", "connection", "content-encoding", "content-length",
+		"content-type", "cookie", "host", "if-modified-since",
+		"if-none-match", "origin", "pragma", "range", "referer",
+		"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site",
+		"sec-fetch-user", "upgrade-insecure-requests", "user-agent",
+		"x-amzn-mtls-clientcert", "x-amzn-mtls-clientcert-issuer",
+		"x-amzn-mtls-clientcert-leaf", "x-amzn-mtls-clientcert-serial-number",
+		"x-amzn-mtls-clientcert-subject", "x-amzn-mtls-clientcert-validity",
+		"x-amzn-oidc-accesstoken", "x-amzn-oidc-data", "x-amzn-oidc-identity",
+		"x-amzn-tls-cipher-suite", "x-amzn-tls-version", "x-amzn-trace-id",
+		"x-forwarded-for", "x-forwarded-port", "x-forwarded-proto",
+		"x-requested-with",
+	} {
+		knownKeys[k] = k
+	}
+}
+
+// internKey returns key as string, avoiding allocation for known keys.
+func (d *jsonDecoder) internKey(key []byte) string {
+	if k, ok := knownKeys[string(key)]; ok {
+		return k
+	}
+	return d.strs.make(key)
+}
+
+// strings decodes JSON array of strings, null decodes as nil slice.
+func (d *jsonDecoder) strings() ([]string, error) {
+	if d.literal("null") {
+		return nil, nil
+	}
+	if d.peek() != '[' {
+		return nil, d.typeError("[]string")
+	}
+	d.pos++
+	vv := []string{}
+	if d.peek() == ']' {
+		d.pos++
+		return vv, nil
+	}
+	for {
+		var v string
+		if err := d.stringValue(&v); err != nil {
+			return nil, err
+		}
+		vv = append(vv, v)
+		switch d.peek() {
+		case ',':
+			d.pos++
+		case ']':
+			d.pos++
+			return vv, nil
+		default:
+			return nil, d.syntaxError("after array element")
+		}
+	}
+}
+
+func (d *jsonDecoder) typeError(want string) error {
+	start := d.pos
+	if err := d.skip(); err != nil {
+		return err
+	}
+	return fmt.Errorf("cannot unmarshal %s into value of type %s", d.data[start:d.pos], want)
+}
+
+// str decodes JSON string at the current position.
+func (d *jsonDecoder) str() (string, error) {
+	s, from, ok, err := d.span()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return d.unquote(d.pos, from)
+	}
+	return d.strs.make(s), nil
+}
+
+// rawStr is like str, but avoids copying strings that need no decoding:
+// they are returned as part of data.
+func (d *jsonDecoder) rawStr() ([]byte, error) {
+	s, from, ok, err := d.span()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		u, err := d.unquote(d.pos, from)
+		return []byte(u), err
+	}
+	return s, nil
+}
+
+// span consumes JSON string at the current position and returns its
+// contents if it has no escape sequences and is valid UTF-8. Otherwise it
+// reports !ok and the offset of the first byte that may need decoding,
+// leaving the position after the opening quote.
+func (d *jsonDecoder) span() (s []byte, from int, ok bool, err error) {
+	d.pos++ // opening quote
+	start := d.pos
+	ascii := true
+	for i := start; i < len(d.data); i++ {
+		switch c := d.data[i]; {
+		case c == '"':
+			s := d.data[start:i]
+			if !ascii && !utf8.Valid(s) {
+				return nil, start, false, nil
+			}
+			d.pos = i + 1
+			return s, 0, true, nil
+		case c == '\\':
+			if !ascii {
+				return nil, start, false, nil
+			}
+			return nil, i, false, nil
+		case c < 0x20:
+			d.pos = i
+			return nil, 0, false, d.syntaxError("in string literal")
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	d.pos = len(d.data)
+	return nil, 0, false, d.syntaxError("")
+}
+
+// unquote decodes JSON string starting at start, which needs escape
+// sequences or invalid UTF-8 replaced. Bytes from start up to from are
+// known to be plain ASCII characters.
+func (d *jsonDecoder) unquote(start, from int) (string, error) {
+	// decoded string is at most as long as the quoted one, unless invalid
+	// UTF-8 is replaced
+	end := from
+	for end < len(d.data) && d.data[end] != '"' {
+		if d.data[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	var b strings.Builder
+	b.Grow(end - start)
+	b.Write(d.data[start:from])
+	for i := from; i < len(d.data); {
+		c := d.data[i]
+		switch {
+		case c == '"':
+			d.pos = i + 1
+			return b.String(), nil
+		case c < 0x20:
+			d.pos = i
+			return "", d.syntaxError("in string literal")
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRune(d.data[i:])
+			b.WriteRune(r)
+			i += size
+			continue
+		case c != '\\':
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		if i == len(d.data) {
+			break
+		}
+		switch c := d.data[i]; c {
+		case '"', '\\', '/':
+			b.WriteByte(c)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r, ok := hexRune(d.data[i+1:])
+			if !ok {
+				d.pos = i
+				return "", d.syntaxError("in \\u hexadecimal character escape")
+			}
+			i += 4
+			if utf16.IsSurrogate(r) {
+				dec := utf8.RuneError
+				if len(d.data)-i > 6 && d.data[i+1] == '\\' && d.data[i+2] == 'u' {
+					if r2, ok := hexRune(d.data[i+3:]); ok {
+						dec = utf16.DecodeRune(r, r2)
+					}
+				}
+				if r = dec; r != utf8.RuneError {
+					i += 6
+				}
+			}
+			b.WriteRune(r)
+		default:
+			d.pos = i
+			return "", d.syntaxError("in string escape code")
+		}
+		i++
+	}
+	d.pos = len(d.data)
+	return "", d.syntaxError("")
+}
+
+// hexRune decodes 4 hex digits at the beginning of b.
+func hexRune(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, false
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, true
+}
+
+// skip consumes any JSON value.
+func (d *jsonDecoder) skip() error {
+	switch c := d.peek(); {
+	case c == '"':
+		_, err := d.rawStr()
+		return err
+	case c == '{' || c == '[':
+		if d.depth++; d.depth > maxJSONDepth {
+			return errors.New("exceeded max depth")
+		}
+		defer func() { d.depth-- }()
+		if c == '{' {
+			d.pos++
+			for {
+				_, ok, err := d.key()
+				if !ok || err != nil {
+					return err
+				}
+				if err := d.skip(); err != nil {
+					return err
+				}
+			}
+		}
+		d.pos++
+		if d.peek() == ']' {
+			d.pos++
+			return nil
+		}
+		for {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			switch d.peek() {
+			case ',':
+				d.pos++
+			case ']':
+				d.pos++
+				return nil
+			default:
+				return d.syntaxError("after array element")
+			}
+		}
+	case c == '-' || '0' <= c && c <= '9':
+		return d.number()
+	case d.literal("true"), d.literal("false"), d.literal("null"):
+		return nil
+	}
+	return d.syntaxError("looking for beginning of value")
+}
+
+// number consumes JSON number.
+func (d *jsonDecoder) number() error {
+	digits := func() int {
+		n := 0
+		for d.pos < len(d.data) && '0' <= d.data[d.pos] && d.data[d.pos] <= '9' {
+			d.pos++
+			n++
+		}
+		return n
+	}
+	if d.data[d.pos] == '-' {
+		d.pos++
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '0' {
+		d.pos++
+	} else if digits() == 0 {
+		return d.syntaxError("in numeric literal")
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == '.' {
+		d.pos++
+		if digits() == 0 {
+			return d.syntaxError("after decimal point in numeric literal")
+		}
+	}
+	if d.pos < len(d.data) && (d.data[d.pos] == 'e' || d.data[d.pos] == 'E') {
+		d.pos++
+		if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+			d.pos++
+		}
+		if digits() == 0 {
+			return d.syntaxError("in exponent of numeric literal")
+		}
+	}
+	return nil
+}
+
+// appendResponse appends r serialized as JSON. Unlike encoding/json, it only
+// escapes characters JSON requires to be escaped, so HTML bodies do not grow
+// in size.
+func appendResponse(dst []byte, r *Response) []byte {
+	dst = append(dst, `{"statusCode":`...)
+	dst = strconv.AppendInt(dst, int64(r.StatusCode), 10)
+	dst = append(dst, `,"statusDescription":`...)
+	dst = appendJSONString(dst, r.Status)
+	dst = append(dst, `,"headers":`...)
+	if r.Headers == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '{')
+		for i, k := range sortedKeys(len(r.Headers), func(keys []string) []string {
+			for k := range r.Headers {
+				keys = append(keys, k)
+			}
+			return keys
+		}) {
+			if i != 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, k)
+			dst = append(dst, ':')
+			dst = appendJSONString(dst, r.Headers[k])
+		}
+		dst = append(dst, '}')
+	}
+	dst = append(dst, `,"multiValueHeaders":`...)
+	if r.MultiValueHeaders == nil {
+		dst = append(dst, "null"...)
+	} else {
+		dst = append(dst, '{')
+		for i, k := range sortedKeys(len(r.MultiValueHeaders), func(keys []string) []string {
+			for k := range r.MultiValueHeaders {
+				keys = append(keys, k)
+			}
+			return keys
+		}) {
+			if i != 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, k)
+			dst = append(dst, ':')
+			vv := r.MultiValueHeaders[k]
+			if vv == nil {
+				dst = append(dst, "null"...)
+				continue
+			}
+			dst = append(dst, '[')
+			for j, v := range vv {
+				if j != 0 {
+					dst = append(dst, ',')
+				}
+				dst = appendJSONString(dst, v)
+			}
+			dst = append(dst, ']')
+		}
+		dst = append(dst, '}')
+	}
+	dst = append(dst, `,"body":`...)
+	if r.raw != nil {
+		n := base64Len(r.raw.Len())
+		dst = append(dst, '"')
+		dst = append(dst, make([]byte, n)...)
+		base64.StdEncoding.Encode(dst[len(dst)-n:], r.raw.Bytes())
+		dst = append(dst, '"')
+	} else {
+		dst = appendJSONString(dst, r.Body)
+	}
+	dst = append(dst, `,"isBase64Encoded":`...)
+	dst = strconv.AppendBool(dst, r.BodyEncoded)
+	return append(dst, '}')
+}
+
+func sortedKeys(n int, collect func([]string) []string) []string {
+	keys := collect(make([]string, 0, n))
+	sort.Strings(keys)
+	return keys
+}
+
+// appendJSONString appends s as JSON string with minimal escaping, see
+// jsonStringLen. Invalid UTF-8 is replaced with U+FFFD.
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if jsonByteLen(c, false) == 1 {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, string(utf8.RuneError)...)
+			start = i + size
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
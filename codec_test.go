@@ -0,0 +1,296 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{name: "single-value", payload: testEvent(`{"hello":"world"}`, false)},
+		{name: "multi-value", payload: `{"httpMethod":"GET","path":"/","multiValueQueryStringParameters":{"a":["1","2"],"b":[]},` +
+			`"multiValueHeaders":{"x-a":["1"],"x-b":null},"body":"","isBase64Encoded":false}`},
+		{name: "escapes", payload: `{"body":"\"\\\/\b\f\n\r\tAé€😀"}`},
+		{name: "lone surrogates", payload: `{"body":"\ud800 \udc00 \ud800A \ud800\ud800"}`},
+		{name: "invalid utf8", payload: "{\"body\":\"a\xffb\xe2\x82\",\"path\":\"\xc3\xa9\"}"},
+		{name: "escapes after text", payload: "{\"body\":\"ab\\ncd\",\"path\":\"\xc3\xa9\\t\",\"httpMethod\":\"\xff\\\"\"}"},
+		{name: "nulls", payload: `{"httpMethod":null,"headers":null,"multiValueHeaders":{"a":null},"queryStringParameters":{"a":null},"isBase64Encoded":null}`},
+		{name: "empty maps", payload: `{"headers":{},"queryStringParameters":{}}`},
+		{name: "case-insensitive keys", payload: `{"HTTPMETHOD":"PUT","Path":"/x","IsBase64Encoded":true,"requestcontext":{"ELB":{"TargetGroupArn":"arn"}}}`},
+		{name: "duplicate keys", payload: `{"path":"/a","path":"/b","headers":{"a":"1"},"headers":{"b":"2"}}`},
+		{name: "unknown fields", payload: `{"x":[1,-2.5e+3,0.1,true,false,null,{"y":[[]]},"z"],"requestContext":{"elb":{"targetGroupArn":"arn","x":{}},"y":1},"path":"/"}`},
+		{name: "whitespace", payload: " \n{ \"path\" :\t\"/\" , \"headers\" : { \"a\" : \"b\" } }\r\n"},
+		{name: "null event", payload: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want, got Event
+			if err := json.Unmarshal([]byte(tt.payload), &want); err != nil {
+				t.Fatalf("encoding/json: %v", err)
+			}
+			if err := decodeEvent([]byte(tt.payload), &got); err != nil {
+				t.Fatalf("decodeEvent: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %#v\nwant %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeEvent_Errors(t *testing.T) {
+	for _, payload := range []string{
+		``,
+		`[]`,
+		`{`,
+		`{"path":"/"`,
+		`{"path":"/"}x`,
+		`{"path":"/",}`,
+		`{,"path":"/"}`,
+		`{"path":"/" "body":""}`,
+		`{"headers":{"a":"b",}}`,
+		`{"headers":{"a":"b" "c":"d"}}`,
+		`{"requestContext":{"elb":{"targetGroupArn":"a",}}}`,
+		`{"x":{"a":1,}}`,
+		`{"x":{,}}`,
+		`{"path":1}`,
+		`{"isBase64Encoded":"true"}`,
+		`{"headers":{"a":1}}`,
+		`{"headers":[]}`,
+		`{"multiValueHeaders":{"a":"b"}}`,
+		`{"multiValueHeaders":{"a":["b",]}}`,
+		`{"body":"\x"}`,
+		`{"body":"\u12"}`,
+		"{\"body\":\"a\nb\"}",
+		`{"body":"abc`,
+		`{"body":"abc\`,
+		`{"x":01}`,
+		`{"x":1.}`,
+		`{"x":-}`,
+		`{"x":tru}`,
+		`{"x":[1 2]}`,
+		strings.Repeat(`{"x":[`, maxJSONDepth) + strings.Repeat(`]}`, maxJSONDepth),
+	} {
+		var ev Event
+		if err := decodeEvent([]byte(payload), &ev); err == nil {
+			t.Errorf("decodeEvent(%.40q) succeeded", payload)
+		}
+		if err := json.Unmarshal([]byte(payload), &ev); err == nil {
+			t.Errorf("json.Unmarshal(%.40q) succeeded", payload)
+		}
+	}
+}
+
+func TestStringCache(t *testing.T) {
+	var c stringCache
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 4*len(c); i++ {
+			b := []byte(strconv.Itoa(i))
+			if s := c.make(b); s != string(b) {
+				t.Fatalf("got %q, want %q", s, b)
+			}
+		}
+	}
+	long := []byte(strings.Repeat("x", maxCachedString+1))
+	if s := c.make(long); s != string(long) {
+		t.Fatalf("got %q, want %q", s, long)
+	}
+}
+
+func TestAppendResponse(t *testing.T) {
+	tests := []*Response{
+		{StatusCode: 200, Status: "200 OK"},
+		{StatusCode: 404, Status: "404 Not Found", Headers: map[string]string{}, MultiValueHeaders: map[string][]string{}},
+		{
+			StatusCode:  302,
+			Status:      "302 Found",
+			Headers:     map[string]string{"Location": "/a?b=<c>&d", "B": "2", "A": "1"},
+			Body:        "<a href=\"/a?b=c&d\">Found</a>.\n",
+			BodyEncoded: false,
+		},
+		{
+			StatusCode:        200,
+			Status:            "200 OK",
+			MultiValueHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}, "X-Empty": {}, "X-Nil": nil},
+			Body:              "aGVsbG8=",
+			BodyEncoded:       true,
+		},
+		{
+			StatusCode: 200,
+			Status:     "200 OK",
+			Body:       "\x00\x01\b\f\n\r\t\x1f\x7f\"\\/ é€😀 \u2028\u2029 \xff\xe2\x82 end",
+		},
+	}
+	for i, r := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			b, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want, got Response
+			if err := json.Unmarshal(b, &want); err != nil {
+				t.Fatal(err)
+			}
+			out := appendResponse(nil, r)
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", out, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %s\nwant %s", out, b)
+			}
+			if len(out) != r.size() {
+				t.Errorf("size() = %d, encoded length %d", r.size(), len(out))
+			}
+			for _, esc := range []string{`\u003c`, `\u003e`, `\u0026`, `\u2028`, `\u2029`} {
+				if bytes.Contains(out, []byte(esc)) {
+					t.Errorf("unnecessary %s escape in %s", esc, out)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendResponse_RawBody(t *testing.T) {
+	for _, body := range []string{"", "a", "ab", "abc", "\x00\xff\xfe binary"} {
+		r := &Response{StatusCode: 200, Status: "200 OK", BodyEncoded: true, raw: bytes.NewBufferString(body)}
+		got := appendResponse(nil, r)
+		if len(got) != r.size() {
+			t.Errorf("%q: size() = %d, encoded length %d", body, r.size(), len(got))
+		}
+		r.encodeBody()
+		if r.raw != nil {
+			t.Error("raw body not released")
+		}
+		want, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	}
+}
+
+func TestInvoker(t *testing.T) {
+	inv := NewInvoker(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.Copy(w, r.Body)
+	}))
+	out, err := inv.Invoke(context.Background(), []byte(testEvent(`{"hello":"world"}`, false)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(out, &resp); err != nil {
+		t.Fatalf("invalid response JSON %s: %v", out, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Body != `{"hello":"world"}` || resp.Headers["Content-Type"] != "application/json" {
+		t.Errorf("unexpected response: %s", out)
+	}
+	if _, err := inv.Invoke(context.Background(), []byte(`{"path":`)); err == nil {
+		t.Error("malformed event accepted")
+	}
+}
+
+// testEvent returns single-value headers ALB event carrying body, with
+// headers typical for a browser request.
+func testEvent(body string, encoded bool) string {
+	ev := Event{
+		Method: "POST",
+		Path:   "/api/v1/items",
+		Query:  map[string]string{"page": "2", "sort": "name%20asc"},
+		Headers: map[string]string{
+			"accept":            "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+			"accept-encoding":   "gzip, deflate, br",
+			"accept-language":   "en-US,en;q=0.9",
+			"content-type":      "application/json",
+			"cookie":            "session=" + strings.Repeat("0123456789abcdef", 16) + "; theme=dark",
+			"host":              "example.com",
+			"user-agent":        "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			"x-amzn-trace-id":   "Root=1-5bdb40ca-556d8b0c50dc66f0511bf520",
+			"x-forwarded-for":   "192.0.2.10",
+			"x-forwarded-port":  "443",
+			"x-forwarded-proto": "https",
+		},
+		Body:        body,
+		BodyEncoded: encoded,
+	}
+	ev.RequestContext.ELB.TargetGroupARN = "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/lambda/1234567890abcdef"
+	b, err := json.Marshal(ev)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func benchmarkPayloads() []struct {
+	name    string
+	payload []byte
+} {
+	item := `{"id":12345,"name":"Widget \"Deluxe\"","tags":["a","b"],"price":12.5},`
+	small := "[" + strings.Repeat(item, 1<<10/len(item)) + "{}]"
+	large := "[" + strings.Repeat(item, 500<<10/len(item)) + "{}]"
+	return []struct {
+		name    string
+		payload []byte
+	}{
+		{"2KB", []byte(testEvent(small, false))},
+		{"500KB", []byte(testEvent(large, false))},
+	}
+}
+
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	io.Copy(w, r.Body)
+})
+
+// BenchmarkRun measures the Handler path, with the event and response passed
+// through encoding/json as aws-lambda-go does.
+func BenchmarkRun(b *testing.B) {
+	fn := Handler(echoHandler)
+	for _, bm := range benchmarkPayloads() {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bm.payload)))
+			for i := 0; i < b.N; i++ {
+				var ev Event
+				if err := json.Unmarshal(bm.payload, &ev); err != nil {
+					b.Fatal(err)
+				}
+				resp, err := fn(context.Background(), ev)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := json.Marshal(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInvoke(b *testing.B) {
+	inv := NewInvoker(echoHandler)
+	for _, bm := range benchmarkPayloads() {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bm.payload)))
+			for i := 0; i < b.N; i++ {
+				if _, err := inv.Invoke(context.Background(), bm.payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package alb
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CompressionConfig configures compression of response bodies, see
+// WithCompression.
+type CompressionConfig struct {
+	// MinSize is the size of the smallest body compressed,
+	// DefaultCompressionMinSize if zero.
+	MinSize int
+	// ContentTypes lists media types of bodies compressed. A single "*" in
+	// media type matches any sequence of characters, as in "text/*" or
+	// "application/*+json". DefaultCompressibleTypes if nil.
+	ContentTypes []string
+}
+
+// DefaultCompressionMinSize is the size of the smallest body compressed by
+// default: smaller bodies do not benefit from compression much.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressibleTypes lists media types compressed by default. Media
+// types of already compressed formats, such as most image formats, are not
+// included.
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// WithCompression enables compression of response bodies with gzip or
+// deflate, as negotiated with the request Accept-Encoding header. Bodies are
+// only compressed if their media type is listed in the configuration, they
+// are not smaller than the configured minimum size, handler did not set
+// Content-Encoding itself, and compression makes them smaller. Compressed
+// bodies are always sent base64-encoded. Limit set with WithResponseLimit
+// applies to the compressed body.
+func WithCompression(c CompressionConfig) Option {
+	return func(h *lambdaHandler) {
+		if c.MinSize == 0 {
+			c.MinSize = DefaultCompressionMinSize
+		}
+		if c.ContentTypes == nil {
+			c.ContentTypes = DefaultCompressibleTypes
+		}
+		h.compression = &c
+	}
+}
+
+// compress compresses body of the recorded response in place if it is
+// eligible for compression.
+func (h *lambdaHandler) compress(r *http.Request, w *responseWriter) {
+	c := h.compression
+	code, header := w.finish() // header changes are visible to respond
+	switch code {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return
+	}
+	if len(w.bytes()) < c.MinSize || header.Get("Content-Encoding") != "" ||
+		strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !matchMediaType(c.ContentTypes, mediaType) {
+		return
+	}
+	addVary(header, "Accept-Encoding")
+	coding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+	if coding == "" {
+		return
+	}
+	buf := getBuffer()
+	var zw io.WriteCloser
+	if coding == "gzip" {
+		zw = gzip.NewWriter(buf)
+	} else {
+		zw = zlib.NewWriter(buf)
+	}
+	zw.Write(w.bytes())
+	zw.Close()
+	if buf.Len() >= len(w.bytes()) {
+		putBuffer(buf)
+		return
+	}
+	putBuffer(w.body)
+	w.body = buf
+	w.encoded = true
+	header.Set("Content-Encoding", coding)
+	header.Del("Content-Length")
+	if etag := header.Get("Etag"); strings.HasPrefix(etag, `"`) {
+		// compressed representation is no longer byte-for-byte identical
+		header.Set("Etag", "W/"+etag)
+	}
+}
+
+// negotiateEncoding picks content coding from Accept-Encoding header values,
+// returning "gzip", "deflate", or empty string if neither is acceptable.
+func negotiateEncoding(accept []string) string {
+	q := map[string]float64{}
+	for _, v := range accept {
+		for _, s := range strings.Split(v, ",") {
+			coding, params := s, ""
+			if i := strings.IndexByte(s, ';'); i >= 0 {
+				coding, params = s[:i], s[i+1:]
+			}
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			weight := 1.0
+			for _, p := range strings.Split(params, ";") {
+				if k, v, ok := cutByte(strings.TrimSpace(p), '='); ok && strings.EqualFold(k, "q") {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						weight = f
+					}
+				}
+			}
+			q[coding] = weight
+		}
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		if _, ok := q[coding]; !ok {
+			if w, ok := q["*"]; ok {
+				q[coding] = w
+			}
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		if w := q[coding]; w > bestQ {
+			best, bestQ = coding, w
+		}
+	}
+	return best
+}
+
+func matchMediaType(patterns []string, mediaType string) bool {
+	for _, p := range patterns {
+		prefix, suffix, wildcard := cutByte(p, '*')
+		if !wildcard && strings.EqualFold(p, mediaType) ||
+			wildcard && len(mediaType) >= len(prefix)+len(suffix) &&
+				strings.EqualFold(mediaType[:len(prefix)], prefix) &&
+				strings.EqualFold(mediaType[len(mediaType)-len(suffix):], suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "*" || strings.EqualFold(s, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// cutByte slices s around the first instance of c.
+func cutByte(s string, c byte) (before, after string, found bool) {
+	if i := strings.IndexByte(s, c); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
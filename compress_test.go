@@ -0,0 +1,217 @@
+package alb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLambdaHandler_Compression(t *testing.T) {
+	text := strings.Repeat("compressible text ", 200)
+	tests := []struct {
+		name         string
+		config       CompressionConfig
+		accept       string
+		header       http.Header
+		body         string
+		wantEncoding string
+		wantVary     string
+		wantEtag     string
+	}{
+		{
+			name:         "gzip",
+			accept:       "gzip, deflate, br",
+			header:       http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Etag": {`"v1"`}},
+			body:         text,
+			wantEncoding: "gzip",
+			wantVary:     "Accept-Encoding",
+			wantEtag:     `W/"v1"`,
+		},
+		{
+			name:         "deflate preferred by quality",
+			accept:       "gzip;q=0.5, deflate",
+			header:       http.Header{"Content-Type": {"application/json"}},
+			body:         text,
+			wantEncoding: "deflate",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:         "wildcard",
+			accept:       "*",
+			header:       http.Header{"Content-Type": {"application/problem+json"}},
+			body:         text,
+			wantEncoding: "gzip",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:     "gzip refused",
+			accept:   "gzip;q=0, identity",
+			header:   http.Header{"Content-Type": {"text/plain"}},
+			body:     text,
+			wantVary: "Accept-Encoding",
+		},
+		{
+			name:     "no accept-encoding",
+			header:   http.Header{"Content-Type": {"text/plain"}, "Vary": {"Origin"}},
+			body:     text,
+			wantVary: "Origin,Accept-Encoding",
+		},
+		{
+			name:   "already compressed type",
+			accept: "gzip",
+			header: http.Header{"Content-Type": {"image/png"}},
+			body:   text,
+		},
+		{
+			name:   "already encoded",
+			accept: "gzip",
+			header: http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"br"}},
+			body:   text,
+		},
+		{
+			name:   "no-transform",
+			accept: "gzip",
+			header: http.Header{"Content-Type": {"text/plain"}, "Cache-Control": {"public, no-transform"}},
+			body:   text,
+		},
+		{
+			name:   "tiny body",
+			accept: "gzip",
+			header: http.Header{"Content-Type": {"text/plain"}},
+			body:   "tiny",
+		},
+		{
+			name:         "custom threshold and types",
+			config:       CompressionConfig{MinSize: 10, ContentTypes: []string{"application/x-ndjson"}},
+			accept:       "gzip",
+			header:       http.Header{"Content-Type": {"application/x-ndjson"}},
+			body:         strings.Repeat("{}\n", 100),
+			wantEncoding: "gzip",
+			wantVary:     "Accept-Encoding",
+		},
+		{
+			name:   "custom types exclude defaults",
+			config: CompressionConfig{ContentTypes: []string{"application/x-ndjson"}},
+			accept: "gzip",
+			header: http.Header{"Content-Type": {"text/plain"}},
+			body:   text,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, vv := range tt.header {
+					w.Header()[k] = vv
+				}
+				io.WriteString(w, tt.body)
+			}), WithCompression(tt.config))
+			req := Event{Method: "GET", Path: "/", Headers: map[string]string{}}
+			if tt.accept != "" {
+				req.Headers["accept-encoding"] = tt.accept
+			}
+			resp, err := fn(context.Background(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := resp.Headers["Content-Encoding"]; got != tt.wantEncoding && got != tt.header.Get("Content-Encoding") {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+			if got := resp.Headers["Vary"]; got != tt.wantVary {
+				t.Errorf("Vary = %q, want %q", got, tt.wantVary)
+			}
+			if tt.wantEtag != "" && resp.Headers["Etag"] != tt.wantEtag {
+				t.Errorf("Etag = %q, want %q", resp.Headers["Etag"], tt.wantEtag)
+			}
+			if tt.wantEncoding == "" {
+				body := resp.Body
+				if resp.BodyEncoded {
+					body = string(mustDecodeBase64(t, body))
+				}
+				if body != tt.body {
+					t.Errorf("body modified: %.40q", body)
+				}
+				return
+			}
+			if !resp.BodyEncoded {
+				t.Fatal("compressed body not base64-encoded")
+			}
+			b, err := base64.StdEncoding.DecodeString(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var zr io.Reader
+			if tt.wantEncoding == "gzip" {
+				zr, err = gzip.NewReader(bytes.NewReader(b))
+			} else {
+				zr, err = zlib.NewReader(bytes.NewReader(b))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("decompressed body = %.40q, want %.40q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestLambdaHandler_CompressionResponseLimit(t *testing.T) {
+	body := strings.Repeat("x", 5000)
+	fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := io.WriteString(w, body); err != nil {
+			t.Errorf("unexpected Write error: %v", err)
+		}
+	}), WithCompression(CompressionConfig{}), WithResponseLimit(1000), WithLogger(log.New(io.Discard, "", 0)))
+
+	resp, err := fn(context.Background(), Event{Method: "GET", Path: "/", Headers: map[string]string{"accept-encoding": "gzip"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Headers["Content-Encoding"] != "gzip" {
+		t.Errorf("compressed response not within limit: status %d, headers %v", resp.StatusCode, resp.Headers)
+	}
+
+	resp, err = fn(context.Background(), Event{Method: "GET", Path: "/", Headers: map[string]string{}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("StatusCode = %d, want %d for uncompressed response", resp.StatusCode, http.StatusInternalServerError)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"identity"}, ""},
+		{[]string{"gzip"}, "gzip"},
+		{[]string{"GZIP"}, "gzip"},
+		{[]string{"deflate"}, "deflate"},
+		{[]string{"br", "deflate"}, "deflate"},
+		{[]string{"gzip, deflate"}, "gzip"},
+		{[]string{"gzip;q=0.2, deflate;q=0.8"}, "deflate"},
+		{[]string{"*;q=0.5, gzip;q=0"}, "deflate"},
+		{[]string{"*;q=0"}, ""},
+		{[]string{"gzip; q=0.001"}, "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
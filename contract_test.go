@@ -0,0 +1,144 @@
+package alb_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichaelFraser99/alb"
+	"github.com/MichaelFraser99/alb/albtest/corpustest"
+)
+
+var update = flag.Bool("update", false, "record responses of the contract test corpus")
+
+// TestContract checks responses to synthetic events in the shape ALB sends,
+// in both header modes, see testdata/contract. Events of each directory are
+// served with the options matching the listener they would come from.
+func TestContract(t *testing.T) {
+	oidc := alb.OIDCConfig{Signers: []string{contractSigner}, Keys: contractKeys{}}
+	for _, tt := range []struct {
+		dir  string
+		opts []alb.Option
+	}{
+		{dir: "default"},
+		{dir: "mtls-verify", opts: []alb.Option{alb.WithMutualTLS(alb.MTLSVerify)}},
+		{dir: "mtls-passthrough", opts: []alb.Option{alb.WithMutualTLS(alb.MTLSPassthrough)}},
+		{dir: "oidc", opts: []alb.Option{alb.WithOIDC(oidc)}},
+	} {
+		t.Run(tt.dir, func(t *testing.T) {
+			c := corpustest.Corpus{Dir: filepath.Join("testdata/contract", tt.dir), Update: *update}
+			c.Run(t, http.HandlerFunc(describeRequest), tt.opts...)
+		})
+	}
+}
+
+// Tokens of the oidc events are signed by contractSigner with a throwaway
+// key, the public half of which is contractKey.
+const (
+	contractSigner = "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/lambda-alb/50dc6c495c0c9188"
+	contractKeyID  = "6c4ec8a1-7e6e-4b4f-9d5c-2f0d1b9a3e77"
+	contractKey    = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE4ZVqISj4hJQMZ+fk+8hToolToq5g
+EPG0CbFVFxkrnGoyyH/ECvMiq0Eh1TiBuYkiuMxQMzV3qZ+Xew7P673D9g==
+-----END PUBLIC KEY-----
+`
+)
+
+// contractKeys is alb.KeyFetcher returning contractKey.
+type contractKeys struct{}
+
+func (contractKeys) FetchKey(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error) {
+	if region != "us-east-1" || keyID != contractKeyID {
+		return nil, fmt.Errorf("no key %s in %s", keyID, region)
+	}
+	block, _ := pem.Decode([]byte(contractKey))
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return key.(*ecdsa.PublicKey), nil
+}
+
+// describeRequest responds with JSON describing the request as the handler
+// sees it. Requests to paths ending with /echo get their body echoed back
+// instead.
+func describeRequest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Set-Cookie", "session=abc; Path=/; HttpOnly")
+	w.Header().Add("Set-Cookie", "theme=dark; Path=/")
+	if strings.HasSuffix(r.URL.Path, "/echo") {
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Write(body)
+		return
+	}
+	type tlsState struct {
+		Version     uint16   `json:"version"`
+		CipherSuite uint16   `json:"cipherSuite"`
+		ServerName  string   `json:"serverName"`
+		PeerCerts   []string `json:"peerCertificates"`
+		Verified    bool     `json:"verified"`
+	}
+	type identity struct {
+		Subject string                 `json:"subject"`
+		Issuer  string                 `json:"issuer"`
+		Expires time.Time              `json:"expires"`
+		Claims  map[string]interface{} `json:"claims"`
+	}
+	desc := struct {
+		Method      string      `json:"method"`
+		URL         string      `json:"url"`
+		Path        string      `json:"path"`
+		Query       interface{} `json:"query"`
+		Host        string      `json:"host"`
+		RemoteAddr  string      `json:"remoteAddr"`
+		Header      http.Header `json:"header"`
+		TLS         *tlsState   `json:"tls"`
+		Body        string      `json:"body"`
+		OIDC        *identity   `json:"oidc"`
+		TargetGroup string      `json:"targetGroup"`
+	}{
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header,
+		Body:       string(body),
+	}
+	if r.TLS != nil {
+		desc.TLS = &tlsState{
+			Version:     r.TLS.Version,
+			CipherSuite: r.TLS.CipherSuite,
+			ServerName:  r.TLS.ServerName,
+			Verified:    len(r.TLS.VerifiedChains) != 0,
+		}
+		for _, c := range r.TLS.PeerCertificates {
+			desc.TLS.PeerCerts = append(desc.TLS.PeerCerts, c.Subject.String())
+		}
+	}
+	if id, ok := alb.OIDCFromContext(r.Context()); ok {
+		desc.OIDC = &identity{Subject: id.Subject, Issuer: id.Issuer, Expires: id.Expires.UTC(), Claims: id.Claims}
+	}
+	if ev, ok := alb.EventFromContext(r.Context()); ok {
+		desc.TargetGroup = ev.RequestContext.ELB.TargetGroupARN
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(desc)
+}
@@ -0,0 +1,169 @@
+package alb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// EventFromRequest converts r to the event ALB would invoke Lambda function
+// with in given header mode, DetectHeaders meaning SingleValueHeaders.
+// Header keys are lowercased, and in single-value mode only the last value
+// of every header and query parameter is kept. Query parameters stay
+// percent-encoded.
+//
+// Request body is read in full. As ALB does, it is passed base64-encoded
+// unless its Content-Type is text/*, application/json, application/javascript
+// or application/xml; bodies of these types that are not valid UTF-8 are
+// base64-encoded too, so that no data is lost. X-Forwarded-* headers ALB
+// adds are left to the caller.
+func EventFromRequest(r *http.Request, mode HeaderMode) (*Event, error) {
+	ev := &Event{
+		Method: r.Method,
+		Path:   r.URL.EscapedPath(),
+	}
+	if ev.Path == "" {
+		ev.Path = "/"
+	}
+	if r.Body != nil {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("alb: reading request body: %w", err)
+		}
+		if albTextBody(r.Header, b) {
+			ev.Body = string(b)
+		} else {
+			ev.Body, ev.BodyEncoded = base64.StdEncoding.EncodeToString(b), true
+		}
+	}
+	headers := make(map[string][]string, len(r.Header)+1)
+	for k, vv := range r.Header {
+		k = strings.ToLower(k)
+		headers[k] = append(headers[k], vv...)
+	}
+	if _, ok := headers["host"]; !ok && r.Host != "" {
+		headers["host"] = []string{r.Host}
+	}
+	query := make(map[string][]string)
+	for _, kv := range strings.Split(r.URL.RawQuery, "&") {
+		if kv == "" {
+			continue
+		}
+		k, v := kv, ""
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			k, v = kv[:i], kv[i+1:]
+		}
+		query[k] = append(query[k], v)
+	}
+	if mode == MultiValueHeaders {
+		ev.MultiValueHeaders, ev.MultiValueQuery = headers, query
+		return ev, nil
+	}
+	ev.Headers, ev.Query = lastValues(headers), lastValues(query)
+	return ev, nil
+}
+
+func lastValues(m map[string][]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, vv := range m {
+		out[k] = vv[len(vv)-1]
+	}
+	return out
+}
+
+// albTextTypes lists media types of request bodies ALB passes as text.
+var albTextTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+}
+
+func albTextBody(h http.Header, body []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && matchMediaType(albTextTypes, mediaType) && utf8.Valid(body)
+}
+
+// RequestFromEvent converts ALB event to http.Request the way the function
+// returned by Handler with default options does, ctx becomes the request
+// context. The returned error is *EventError.
+func RequestFromEvent(ctx context.Context, ev *Event) (*http.Request, error) {
+	var h lambdaHandler
+	r, err := h.request(ctx, ev)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WriteResponse writes response ALB received from Lambda function to w, the
+// way ALB passes it to the client. Multiple values of a header sent in
+// single-value mode with PermuteHeaderCase are restored in their original
+// order. Status description cannot be passed through http.ResponseWriter and
+// is ignored.
+//
+// Nothing is written if the response is malformed: has invalid status code
+// or body that is not valid base64.
+func WriteResponse(w http.ResponseWriter, resp *Response) error {
+	if resp.StatusCode < 100 || resp.StatusCode > 999 {
+		return fmt.Errorf("alb: invalid response status code %d", resp.StatusCode)
+	}
+	var body io.Reader = strings.NewReader(resp.Body)
+	if resp.BodyEncoded {
+		var err error
+		if body, _, err = base64Body(resp.Body); err != nil {
+			return fmt.Errorf("alb: malformed response body: %w", err)
+		}
+	}
+	header := w.Header()
+	if resp.MultiValueHeaders != nil {
+		for k, vv := range resp.MultiValueHeaders {
+			k = textproto.CanonicalMIMEHeaderKey(k)
+			header[k] = append(header[k], vv...)
+		}
+	} else {
+		keys := make([]string, 0, len(resp.Headers))
+		for k := range resp.Headers {
+			keys = append(keys, k)
+		}
+		// case permutations of the same key sort in the order values were
+		// assigned to them by singleValueHeaders
+		sort.Slice(keys, func(i, j int) bool {
+			ki, kj := textproto.CanonicalMIMEHeaderKey(keys[i]), textproto.CanonicalMIMEHeaderKey(keys[j])
+			if ki != kj {
+				return ki < kj
+			}
+			return caseIndex(keys[i], ki) < caseIndex(keys[j], kj)
+		})
+		for _, k := range keys {
+			header.Add(k, resp.Headers[k])
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err := io.Copy(w, body)
+	return err
+}
+
+// caseIndex is the inverse of permuteCase: it returns n such that s is n-th
+// case permutation of base.
+func caseIndex(s, base string) int {
+	var n, bit int
+	for i := 0; i < len(s) && i < len(base); i++ {
+		c := base[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			continue
+		}
+		if s[i] != c {
+			n |= 1 << bit
+		}
+		bit++
+	}
+	return n
+}
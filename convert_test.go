@@ -0,0 +1,292 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// requestCase is a random request for property-based tests. In single-value
+// mode header and query keys are distinct, as ALB only passes the last
+// value of each.
+type requestCase struct {
+	mode HeaderMode
+	req  *http.Request
+	body []byte
+}
+
+func (requestCase) Generate(rnd *rand.Rand, size int) reflect.Value {
+	tc := requestCase{mode: SingleValueHeaders}
+	if rnd.Intn(2) == 0 {
+		tc.mode = MultiValueHeaders
+	}
+	values := 1
+	if tc.mode == MultiValueHeaders {
+		values = 3
+	}
+	var path strings.Builder
+	for i := rnd.Intn(4); i >= 0; i-- {
+		path.WriteString("/" + randString(rnd, size))
+	}
+	query := url.Values{}
+	for i := rnd.Intn(4); i > 0; i-- {
+		k := randString(rnd, 8)
+		query.Del(k)
+		for j := rnd.Intn(values) + 1; j > 0; j-- {
+			query.Add(k, randString(rnd, size))
+		}
+	}
+	tc.body = randBody(rnd, size)
+	req := httptest.NewRequest(
+		[]string{"GET", "POST", "PUT", "DELETE", "PATCH"}[rnd.Intn(5)],
+		(&url.URL{Path: path.String(), RawQuery: query.Encode()}).RequestURI(),
+		bytes.NewReader(tc.body),
+	)
+	req.Host = []string{"example.com", "example.com:8443", "127.0.0.1:8080"}[rnd.Intn(3)]
+	req.Header = randHeader(rnd, size, values)
+	req.Header.Set("Content-Type", []string{"text/plain; charset=utf-8", "application/json", "application/octet-stream", "image/png"}[rnd.Intn(4)])
+	tc.req = req
+	return reflect.ValueOf(tc)
+}
+
+// randString returns random string of printable characters, including ones
+// that need escaping in URLs and JSON.
+func randString(rnd *rand.Rand, size int) string {
+	const chars = "abcXYZ019 -_.~%&=+?#/<>\"'\\é世🌍"
+	r := []rune(chars)
+	var b strings.Builder
+	for i := rnd.Intn(size + 1); i >= 0; i-- {
+		b.WriteRune(r[rnd.Intn(len(r))])
+	}
+	return b.String()
+}
+
+func randBody(rnd *rand.Rand, size int) []byte {
+	if rnd.Intn(2) == 0 {
+		return []byte(randString(rnd, size*4))
+	}
+	b := make([]byte, rnd.Intn(size*4+1))
+	rnd.Read(b)
+	return b
+}
+
+// randHeader returns header with up to given number of values per key.
+// Values have no leading or trailing whitespace, which HTTP does not keep.
+func randHeader(rnd *rand.Rand, size, values int) http.Header {
+	h := http.Header{}
+	for i := rnd.Intn(5); i > 0; i-- {
+		k := fmt.Sprintf("X-Test-%d", rnd.Intn(10))
+		h.Del(k)
+		for j := rnd.Intn(values) + 1; j > 0; j-- {
+			h.Add(k, "v"+strings.Replace(randString(rnd, size), " ", "_", -1))
+		}
+	}
+	return h
+}
+
+func TestEventFromRequest_RoundTrip(t *testing.T) {
+	f := func(tc requestCase) bool {
+		ev, err := EventFromRequest(tc.req, tc.mode)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		// pass the event through JSON as ALB does
+		b, err := json.Marshal(ev)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		var wire Event
+		if err := decodeEvent(b, &wire); err != nil {
+			t.Log(err)
+			return false
+		}
+		if tc.mode == MultiValueHeaders && (wire.MultiValueHeaders == nil || wire.Headers != nil) ||
+			tc.mode == SingleValueHeaders && (wire.Headers == nil || wire.MultiValueHeaders != nil) {
+			t.Logf("event does not match %v mode: %s", tc.mode, b)
+			return false
+		}
+		for k := range wire.HeadersProvided() {
+			if k != strings.ToLower(k) {
+				t.Logf("header key %q not lowercased", k)
+				return false
+			}
+		}
+		r, err := RequestFromEvent(context.Background(), &wire)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		wantHeader := tc.req.Header.Clone()
+		wantHeader.Set("Host", tc.req.Host)
+		wantQuery, _ := url.ParseQuery(tc.req.URL.RawQuery)
+		gotQuery, _ := url.ParseQuery(r.URL.RawQuery)
+		switch {
+		case r.Method != tc.req.Method:
+			t.Logf("method %q, want %q", r.Method, tc.req.Method)
+		case r.URL.EscapedPath() != tc.req.URL.EscapedPath():
+			t.Logf("path %q, want %q", r.URL.EscapedPath(), tc.req.URL.EscapedPath())
+		case !reflect.DeepEqual(gotQuery, wantQuery):
+			t.Logf("query %v, want %v", gotQuery, wantQuery)
+		case r.Host != tc.req.Host:
+			t.Logf("host %q, want %q", r.Host, tc.req.Host)
+		case !reflect.DeepEqual(r.Header, wantHeader):
+			t.Logf("header %v, want %v", r.Header, wantHeader)
+		case !bytes.Equal(body, tc.body) || r.ContentLength != int64(len(tc.body)):
+			t.Logf("body %q (%d), want %q", body, r.ContentLength, tc.body)
+		default:
+			return true
+		}
+		return false
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// responseCase is a random handler response for property-based tests. In
+// single-value mode only Set-Cookie header has multiple values, as others
+// are joined.
+type responseCase struct {
+	mode   HeaderMode
+	code   int
+	header http.Header
+	body   []byte
+}
+
+func (responseCase) Generate(rnd *rand.Rand, size int) reflect.Value {
+	tc := responseCase{mode: SingleValueHeaders}
+	if rnd.Intn(2) == 0 {
+		tc.mode = MultiValueHeaders
+	}
+	values := 1
+	if tc.mode == MultiValueHeaders {
+		values = 3
+	}
+	tc.code = []int{200, 201, 301, 400, 404, 418, 500, 503}[rnd.Intn(8)]
+	tc.header = randHeader(rnd, size, values)
+	for i := rnd.Intn(4); i > 0; i-- {
+		tc.header.Add("Set-Cookie", fmt.Sprintf("c%d=%d", i, rnd.Int()))
+	}
+	contentTypes := []string{"text/html; charset=utf-8", "application/json", "application/octet-stream", "image/png"}
+	tc.header.Set("Content-Type", contentTypes[rnd.Intn(len(contentTypes))])
+	tc.body = randBody(rnd, size)
+	return reflect.ValueOf(tc)
+}
+
+func TestWriteResponse_RoundTrip(t *testing.T) {
+	f := func(tc responseCase) bool {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for k, vv := range tc.header {
+				w.Header()[k] = vv
+			}
+			w.WriteHeader(tc.code)
+			w.Write(tc.body)
+		})
+		ev := Event{Method: "GET", Path: "/", Headers: map[string]string{}}
+		if tc.mode == MultiValueHeaders {
+			ev = Event{Method: "GET", Path: "/", MultiValueHeaders: map[string][]string{}}
+		}
+		out, err := NewInvoker(handler, WithHeaderMode(tc.mode)).Invoke(context.Background(), mustMarshal(t, ev))
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		var resp Response
+		if err := json.Unmarshal(out, &resp); err != nil {
+			t.Log(err)
+			return false
+		}
+		w := httptest.NewRecorder()
+		if err := WriteResponse(w, &resp); err != nil {
+			t.Log(err)
+			return false
+		}
+		switch {
+		case w.Code != tc.code:
+			t.Logf("status %d, want %d", w.Code, tc.code)
+		case !reflect.DeepEqual(w.Header(), tc.header):
+			t.Logf("header %v, want %v", w.Header(), tc.header)
+		case !bytes.Equal(w.Body.Bytes(), tc.body):
+			t.Logf("body %q, want %q", w.Body.Bytes(), tc.body)
+		default:
+			return true
+		}
+		return false
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEventFromRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/a%2Fb/c?x=1&y=a%20b&x=2&flag", strings.NewReader(`{"a":1}`))
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "*/*")
+	r.Header.Set("Content-Type", "application/json")
+	ev, err := EventFromRequest(r, SingleValueHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Event{
+		Method:  "POST",
+		Path:    "/a%2Fb/c",
+		Query:   map[string]string{"x": "2", "y": "a%20b", "flag": ""},
+		Headers: map[string]string{"accept": "*/*", "content-type": "application/json", "host": "example.com"},
+		Body:    `{"a":1}`,
+	}
+	if !reflect.DeepEqual(ev, want) {
+		t.Errorf("got  %+v\nwant %+v", ev, want)
+	}
+
+	r = httptest.NewRequest("PUT", "/", bytes.NewReader([]byte{0xff, 0}))
+	r.Header.Set("Content-Type", "text/plain")
+	ev, err = EventFromRequest(r, MultiValueHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ev.BodyEncoded || ev.Body != "/wA=" || len(ev.MultiValueQuery) != 0 || ev.MultiValueQuery == nil {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestWriteResponse_Malformed(t *testing.T) {
+	for _, resp := range []*Response{
+		{StatusCode: 0},
+		{StatusCode: 1000},
+		{StatusCode: 200, Body: "!", BodyEncoded: true},
+	} {
+		w := httptest.NewRecorder()
+		if err := WriteResponse(w, resp); err == nil {
+			t.Errorf("%+v: no error", resp)
+		}
+		if w.Code != http.StatusOK || len(w.Header()) != 0 || w.Body.Len() != 0 {
+			t.Errorf("%+v: malformed response written", resp)
+		}
+	}
+}
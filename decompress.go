@@ -0,0 +1,72 @@
+package alb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecompressedSize is the default limit of request body size after
+// decompression, see WithRequestDecompression.
+const DefaultMaxDecompressedSize = 10 << 20
+
+// WithRequestDecompression makes request bodies with gzip or deflate
+// Content-Encoding decompressed before they reach the handler, with
+// Content-Encoding header removed and ContentLength set to the decompressed
+// size. Bodies decompressing to more than max bytes (DefaultMaxDecompressedSize
+// if max <= 0) are rejected with 413 Request Entity Too Large, malformed ones
+// with 400 Bad Request. Bodies with other content codings are passed as is.
+func WithRequestDecompression(max int64) Option {
+	return func(h *lambdaHandler) {
+		if max <= 0 {
+			max = DefaultMaxDecompressedSize
+		}
+		h.maxDecompressed = max
+	}
+}
+
+// decompressBody replaces compressed request body with its decompressed
+// version. It returns non-zero status code request must be rejected with if
+// body cannot be decompressed.
+func decompressBody(r *http.Request, max int64) int {
+	coding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if r.ContentLength == 0 || coding != "gzip" && coding != "x-gzip" && coding != "deflate" {
+		return 0
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	var zr io.Reader
+	switch coding {
+	case "deflate":
+		// "deflate" is zlib format, but some clients send raw deflate
+		if zr, err = zlib.NewReader(bytes.NewReader(raw)); err == zlib.ErrHeader {
+			zr, err = flate.NewReader(bytes.NewReader(raw)), nil
+		}
+	default:
+		zr, err = gzip.NewReader(bytes.NewReader(raw))
+	}
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	b, err := io.ReadAll(io.LimitReader(zr, max+1))
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	if int64(len(b)) > max {
+		return http.StatusRequestEntityTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	r.Header.Del("Content-Encoding")
+	if r.Header.Get("Content-Length") != "" {
+		r.Header.Set("Content-Length", strconv.Itoa(len(b)))
+	}
+	return 0
+}
@@ -0,0 +1,152 @@
+package alb
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLambdaHandler_RequestDecompression(t *testing.T) {
+	payload := `{"message":"` + strings.Repeat("hello ", 100) + `"}`
+	compress := func(newWriter func(io.Writer) io.WriteCloser, s string) string {
+		var buf bytes.Buffer
+		zw := newWriter(&buf)
+		io.WriteString(zw, s)
+		zw.Close()
+		return base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	gzipped := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }, payload)
+	zlibbed := compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }, payload)
+	deflated := compress(func(w io.Writer) io.WriteCloser { zw, _ := flate.NewWriter(w, flate.DefaultCompression); return zw }, payload)
+
+	tests := []struct {
+		name       string
+		max        int64
+		encoding   string
+		body       string
+		encoded    bool
+		wantStatus int
+		wantBody   string
+		wantCoding string
+	}{
+		{
+			name:       "gzip",
+			encoding:   "gzip",
+			body:       gzipped,
+			encoded:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "zlib deflate",
+			encoding:   "deflate",
+			body:       zlibbed,
+			encoded:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "raw deflate",
+			encoding:   "Deflate",
+			body:       deflated,
+			encoded:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "uncompressed",
+			body:       payload,
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "unsupported encoding passed as is",
+			encoding:   "br",
+			body:       "brotli data",
+			wantStatus: http.StatusOK,
+			wantBody:   "brotli data",
+			wantCoding: "br",
+		},
+		{
+			name:       "malformed gzip",
+			encoding:   "gzip",
+			body:       "not gzip",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "truncated gzip",
+			encoding:   "gzip",
+			body:       base64.StdEncoding.EncodeToString(mustDecodeBase64(t, gzipped)[:30]),
+			encoded:    true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "decompressed size limit",
+			max:        100,
+			encoding:   "gzip",
+			body:       gzipped,
+			encoded:    true,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.ContentLength != int64(len(b)) {
+					t.Errorf("ContentLength = %d, want %d", r.ContentLength, len(b))
+				}
+				if got := r.Header.Get("Content-Length"); got != strconv.Itoa(len(b)) {
+					t.Errorf("Content-Length header = %q, want %d", got, len(b))
+				}
+				if got := r.Header.Get("Content-Encoding"); got != tt.wantCoding {
+					t.Errorf("Content-Encoding = %q, want %q", got, tt.wantCoding)
+				}
+				w.Write(b)
+			}), WithRequestDecompression(tt.max))
+			headers := map[string]string{"content-length": strconv.Itoa(len(tt.body))}
+			if tt.encoded {
+				headers["content-length"] = strconv.Itoa(len(mustDecodeBase64(t, tt.body)))
+			}
+			if tt.encoding != "" {
+				headers["content-encoding"] = tt.encoding
+			}
+			resp, err := fn(context.Background(), Event{
+				Method:      "POST",
+				Path:        "/",
+				Headers:     headers,
+				Body:        tt.body,
+				BodyEncoded: tt.encoded,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && resp.Body != tt.wantBody {
+				t.Errorf("Body = %.40q, want %.40q", resp.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func mustDecodeBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+package alb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MichaelFraser99/alb/internal/emulation"
+)
+
+// maxRequestBody is the maximum size of the request body ALB passes to
+// Lambda function, larger requests are answered with 413 Request Entity
+// Too Large.
+const maxRequestBody = 1 << 20
+
+// ListenAndServe listens on the TCP network address addr and serves
+// requests with h the way it would serve them behind ALB, see Emulate. It is
+// meant for local development; options are applied as with Handler.
+func ListenAndServe(addr string, h http.Handler, opts ...Option) error {
+	return (&http.Server{Addr: addr, Handler: Emulate(h, opts...)}).ListenAndServe()
+}
+
+// Emulate returns handler emulating ALB in front of Lambda function serving
+// requests with h, options are applied as with Handler. Every request is
+// converted to the event ALB would send, passed through JSON to the same
+// function Handler returns, and its JSON-encoded response is written back
+// to the client.
+//
+// The target group header mode is set with WithHeaderMode, DetectHeaders
+// meaning single-value headers as ALB does by default. As ALB does, the
+// handler sets Content-Length, X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Port and X-Amzn-Trace-Id headers, answers requests with body
+// over 1 MB with 413 Request Entity Too Large, and answers with 502 Bad
+// Gateway if the invocation fails or the response is malformed or exceeds
+// 1 MB. Deadline of the emulated invocation is that of the request context,
+// if any. Events carry a placeholder target group ARN in the local region.
+func Emulate(h http.Handler, opts ...Option) http.Handler {
+	if h == nil {
+		panic("Emulate called with nil handler")
+	}
+	hh := newLambdaHandler(h, opts)
+	mode := hh.headerMode
+	if mode == DetectHeaders {
+		mode = SingleValueHeaders
+	}
+	return &emulator{h: hh, mode: mode}
+}
+
+type emulator struct {
+	h    *lambdaHandler
+	mode HeaderMode
+}
+
+func (e *emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	if r.Body != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				errorHandler(http.StatusRequestEntityTooLarge)(w, r)
+				return
+			}
+			e.h.logf("alb: emulator: %v", err)
+			errorHandler(http.StatusBadRequest)(w, r)
+			return
+		}
+		r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	}
+	addForwardedHeaders(r)
+	trace := emulation.ContextTrace(r.Context())
+	if trace != nil && trace.Forwarded != nil {
+		trace.Forwarded(r.Header)
+	}
+	ev, err := EventFromRequest(r, e.mode)
+	if err != nil {
+		e.h.logf("alb: emulator: %v", err)
+		errorHandler(http.StatusBadRequest)(w, r)
+		return
+	}
+	ev.RequestContext.ELB.TargetGroupARN = emulation.TargetGroupARN
+	resp, err := e.invoke(r, ev, trace)
+	if err != nil {
+		e.h.logf("alb: emulator: %s %s: %v", r.Method, r.URL, err)
+		errorHandler(http.StatusBadGateway)(w, r)
+		return
+	}
+	if err := WriteResponse(w, resp); err != nil {
+		e.h.logf("alb: emulator: %s %s: %v", r.Method, r.URL, err)
+		errorHandler(http.StatusBadGateway)(w, r)
+	}
+}
+
+// invoke passes event to the function as JSON and decodes its response,
+// checking the response size as ALB does.
+func (e *emulator) invoke(r *http.Request, ev *Event, trace *emulation.Trace) (*Response, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	var in Event
+	if err := json.Unmarshal(payload, &in); err != nil {
+		return nil, err
+	}
+	resp, err := e.h.Run(r.Context(), in)
+	var out []byte
+	if err == nil {
+		out, err = json.Marshal(resp)
+	}
+	if trace != nil && trace.Invoked != nil {
+		trace.Invoked(payload, out, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invocation failed: %w", err)
+	}
+	if len(out) > DefaultResponseLimit {
+		return nil, fmt.Errorf("response JSON is %d bytes, exceeding the limit of %d bytes", len(out), DefaultResponseLimit)
+	}
+	resp = new(Response)
+	if err := json.Unmarshal(out, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// addForwardedHeaders adds headers ALB adds to requests it forwards.
+func addForwardedHeaders(r *http.Request) {
+	if r.ContentLength > 0 || r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		r.Header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) != 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	r.Header.Set("X-Forwarded-Proto", proto)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			r.Header.Set("X-Forwarded-Port", port)
+		}
+	}
+	if r.Header.Get("X-Amzn-Trace-Id") == "" {
+		r.Header.Set("X-Amzn-Trace-Id", newTraceID())
+	}
+}
+
+// newTraceID returns X-Amzn-Trace-Id header value in the format ALB uses.
+func newTraceID() string {
+	var b [12]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("Root=1-%08x-%s", time.Now().Unix(), hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,173 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MichaelFraser99/alb/internal/emulation"
+)
+
+func TestEmulate(t *testing.T) {
+	for _, mode := range []HeaderMode{DetectHeaders, SingleValueHeaders, MultiValueHeaders} {
+		t.Run(mode.String(), func(t *testing.T) {
+			var ev *Event
+			var remote string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ev, _ = EventFromContext(r.Context())
+				remote = r.RemoteAddr
+				w.Header().Add("Set-Cookie", "a=1")
+				w.Header().Add("Set-Cookie", "b=2")
+				w.Header().Set("Content-Type", "application/octet-stream")
+				io.Copy(w, r.Body)
+			})
+			srv := httptest.NewServer(Emulate(handler, WithHeaderMode(mode)))
+			defer srv.Close()
+
+			req, err := http.NewRequest("POST", srv.URL+"/a%2Fb?x=1&x=2", strings.NewReader("\x00\xff"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			req.Header.Add("Accept", "text/html")
+			req.Header.Add("Accept", "*/*")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK || string(body) != "\x00\xff" {
+				t.Errorf("got %s %q, want 200 with request body", resp.Status, body)
+			}
+			if got := resp.Header.Values("Set-Cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+				t.Errorf("Set-Cookie = %q", got)
+			}
+			if ev == nil {
+				t.Fatal("no event in request context")
+			}
+			if ev.RequestContext.ELB.TargetGroupARN != emulation.TargetGroupARN || ev.Path != "/a%2Fb" || !ev.BodyEncoded {
+				t.Errorf("unexpected event: %+v", ev)
+			}
+			if got := ev.headerMode(); got != mode && (mode != DetectHeaders || got != SingleValueHeaders) {
+				t.Errorf("event has %v", got)
+			}
+			headers := ev.HeadersProvided()
+			for k := range headers {
+				if k != strings.ToLower(k) {
+					t.Errorf("header %q is not lowercased", k)
+				}
+			}
+			_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+			if got := headers["x-forwarded-for"]; len(got) != 1 || got[0] != "192.0.2.1, 127.0.0.1" {
+				t.Errorf("x-forwarded-for = %q", got)
+			}
+			if got := headers["x-forwarded-port"]; len(got) != 1 || got[0] != port {
+				t.Errorf("x-forwarded-port = %q, want %s", got, port)
+			}
+			if got := headers["x-forwarded-proto"]; len(got) != 1 || got[0] != "http" {
+				t.Errorf("x-forwarded-proto = %q", got)
+			}
+			if got := headers["x-amzn-trace-id"]; len(got) != 1 || !strings.HasPrefix(got[0], "Root=1-") {
+				t.Errorf("x-amzn-trace-id = %q", got)
+			}
+			wantAccept, wantQuery := []string{"*/*"}, []string{"2"}
+			if mode == MultiValueHeaders {
+				wantAccept, wantQuery = []string{"text/html", "*/*"}, []string{"1", "2"}
+			}
+			if got := headers["accept"]; !reflect.DeepEqual(got, wantAccept) {
+				t.Errorf("accept = %q, want %q", got, wantAccept)
+			}
+			if got := ev.QueryProvided()["x"]; !reflect.DeepEqual(got, wantQuery) {
+				t.Errorf("query x = %q, want %q", got, wantQuery)
+			}
+			if remote != net.JoinHostPort("127.0.0.1", port) {
+				t.Errorf("RemoteAddr = %q", remote)
+			}
+		})
+	}
+}
+
+func TestEmulate_Trace(t *testing.T) {
+	var header http.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		io.WriteString(w, "ok")
+	})
+	var event, response []byte
+	var invokeErr error
+	ctx := emulation.WithTrace(context.Background(), &emulation.Trace{
+		Forwarded: func(h http.Header) { h.Del("X-Amzn-Trace-Id") },
+		Invoked:   func(ev, resp []byte, err error) { event, response, invokeErr = ev, resp, err },
+	})
+	r := httptest.NewRequest("POST", "/", strings.NewReader("body")).WithContext(ctx)
+	w := httptest.NewRecorder()
+	Emulate(handler).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, ok := header["X-Amzn-Trace-Id"]; ok {
+		t.Error("header removed by Forwarded hook reached handler")
+	}
+	if got := header.Get("Content-Length"); got != "4" {
+		t.Errorf("Content-Length = %q, want %q", got, "4")
+	}
+	if !bytes.Contains(event, []byte(`"body":"Ym9keQ=="`)) || !bytes.Contains(response, []byte(`"body":"ok"`)) || invokeErr != nil {
+		t.Errorf("Invoked(%s, %s, %v)", event, response, invokeErr)
+	}
+}
+
+func TestEmulate_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		handler    http.HandlerFunc
+		body       string
+		wantStatus int
+		wantLog    string
+	}{
+		{
+			name:       "request body too large",
+			handler:    func(w http.ResponseWriter, r *http.Request) { t.Error("handler called") },
+			body:       strings.Repeat("x", maxRequestBody+1),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "invocation error",
+			handler:    func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) },
+			wantStatus: http.StatusBadGateway,
+			wantLog:    "invocation failed",
+		},
+		{
+			name: "response too large",
+			opts: []Option{WithResponseLimit(0)},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, strings.Repeat("x", DefaultResponseLimit))
+			},
+			wantStatus: http.StatusBadGateway,
+			wantLog:    "exceeding the limit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := append([]Option{WithLogger(log.New(&buf, "", 0))}, tt.opts...)
+			w := httptest.NewRecorder()
+			Emulate(tt.handler, opts...).ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Errorf("log %q does not mention %q", buf.String(), tt.wantLog)
+			}
+		})
+	}
+}
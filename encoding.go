@@ -0,0 +1,86 @@
+package alb
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// EncodingPolicy reports whether response body with given header must be
+// sent to ALB base64-encoded rather than as a JSON string. Bodies sent as a
+// string must be valid UTF-8, as invalid sequences are replaced with U+FFFD.
+type EncodingPolicy func(header http.Header, body []byte) bool
+
+// WithEncodingPolicy sets policy deciding which response bodies are sent
+// base64-encoded. The default is ContentTypePolicy(nil, DefaultBinaryTypes),
+// which sends bodies of binary media types base64-encoded, and others as
+// text only if they are valid UTF-8.
+func WithEncodingPolicy(p EncodingPolicy) Option {
+	return func(h *lambdaHandler) { h.encodingPolicy = p }
+}
+
+// UTF8Policy is an EncodingPolicy sending body base64-encoded unless it is
+// valid UTF-8.
+func UTF8Policy(_ http.Header, body []byte) bool { return !utf8.Valid(body) }
+
+// DefaultTextTypes lists media types commonly sent as UTF-8 text, for use
+// with ContentTypePolicy by handlers known to send them so.
+var DefaultTextTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/x-www-form-urlencoded",
+}
+
+// DefaultBinaryTypes lists media types sent base64-encoded by default.
+var DefaultBinaryTypes = []string{
+	"image/*",
+	"audio/*",
+	"video/*",
+	"font/*",
+	"application/octet-stream",
+	"application/pdf",
+	"application/zip",
+	"application/gzip",
+	"application/protobuf",
+	"application/x-protobuf",
+	"application/vnd.google.protobuf",
+	"application/grpc*",
+}
+
+// ContentTypePolicy returns an EncodingPolicy deciding by response
+// Content-Encoding and Content-Type headers. Bodies with Content-Encoding
+// other than identity, or with media type matching one of binary patterns
+// are sent base64-encoded. Bodies with media type matching one of text
+// patterns and either no charset parameter or UTF-8 compatible one are sent
+// as text without checking them, so invalid UTF-8 in them is replaced with
+// U+FFFD: list only types the handler is known to send as UTF-8. Other
+// bodies are handled as by UTF8Policy. Patterns are matched as described in
+// CompressionConfig.ContentTypes.
+func ContentTypePolicy(text, binary []string) EncodingPolicy {
+	return func(header http.Header, body []byte) bool {
+		if ce := header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+			return true
+		}
+		mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			return UTF8Policy(header, body)
+		}
+		if matchMediaType(binary, mediaType) {
+			return true
+		}
+		if matchMediaType(text, mediaType) {
+			switch charset, ok := params["charset"]; {
+			case !ok, strings.EqualFold(charset, "utf-8"), strings.EqualFold(charset, "utf8"), strings.EqualFold(charset, "us-ascii"):
+				return false
+			}
+		}
+		return UTF8Policy(header, body)
+	}
+}
+
+var defaultEncodingPolicy = ContentTypePolicy(nil, DefaultBinaryTypes)
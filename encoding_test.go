@@ -0,0 +1,101 @@
+package alb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestContentTypePolicy(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(`{"hello":"world"}`))
+	zw.Close()
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		want        bool
+	}{
+		{name: "png image", contentType: "image/png", body: []byte("\x89PNG\r\n\x1a\n"), want: true},
+		{name: "valid utf8 image", contentType: "image/gif", body: []byte("GIF89a"), want: true},
+		{name: "svg image", contentType: "image/svg+xml", body: []byte("<svg/>"), want: true},
+		{name: "protobuf", contentType: "application/x-protobuf", body: []byte("\x0a\x05hello"), want: true},
+		{name: "grpc", contentType: "application/grpc+proto", body: []byte("hello"), want: true},
+		{name: "utf8 octet-stream", contentType: "application/octet-stream", body: []byte("hello"), want: true},
+		{name: "gzip-encoded json", contentType: "application/json", encoding: "gzip", body: gz.Bytes(), want: true},
+		{name: "identity-encoded json", contentType: "application/json", encoding: "identity", body: []byte(`{}`)},
+		{name: "json", contentType: "application/json", body: []byte(`{"a":1}`)},
+		{name: "problem json", contentType: "application/problem+json", body: []byte(`{}`)},
+		{name: "html utf-8 charset", contentType: "text/html; charset=UTF-8", body: []byte("<p>é</p>")},
+		{name: "quoted charset", contentType: `text/plain; charset="utf-8"`, body: []byte("é")},
+		{name: "latin1 charset", contentType: "text/plain; charset=iso-8859-1", body: []byte("caf\xe9"), want: true},
+		{name: "latin1 charset ascii body", contentType: "text/plain; charset=iso-8859-1", body: []byte("cafe")},
+		{name: "unknown type utf8", contentType: "application/x-custom", body: []byte("hello")},
+		{name: "unknown type binary", contentType: "application/x-custom", body: []byte{0xff, 0xfe}, want: true},
+		{name: "no content type", body: []byte("hello")},
+		{name: "malformed content type", contentType: "text/", body: []byte{0xff}, want: true},
+	}
+	policy := ContentTypePolicy(DefaultTextTypes, DefaultBinaryTypes)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.contentType != "" {
+				header.Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				header.Set("Content-Encoding", tt.encoding)
+			}
+			if got := policy(header, tt.body); got != tt.want {
+				t.Errorf("policy(%q, %q) = %v, want %v", tt.contentType, tt.encoding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLambdaHandler_EncodingPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		contentType string
+		body        string
+		wantEncoded bool
+		wantBody    string
+	}{
+		{name: "default", contentType: "application/octet-stream", body: "hello", wantEncoded: true, wantBody: "aGVsbG8="},
+		{name: "default text", contentType: "text/plain", body: "café", wantBody: "café"},
+		{name: "default invalid text", contentType: "text/plain", body: "caf\xe9", wantEncoded: true, wantBody: "Y2Fm6Q=="},
+		{
+			name:        "utf8 heuristic",
+			opts:        []Option{WithEncodingPolicy(UTF8Policy)},
+			contentType: "application/octet-stream",
+			body:        "hello",
+			wantBody:    "hello",
+		},
+		{
+			name:        "custom lists",
+			opts:        []Option{WithEncodingPolicy(ContentTypePolicy([]string{"application/octet-stream"}, nil))},
+			contentType: "application/octet-stream",
+			body:        "hello",
+			wantBody:    "hello",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			})
+			resp, err := Handler(handler, tt.opts...)(context.Background(), Event{Method: "GET", Path: "/"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.BodyEncoded != tt.wantEncoded || resp.Body != tt.wantBody {
+				t.Errorf("got body %q (encoded: %v), want %q (encoded: %v)", resp.Body, resp.BodyEncoded, tt.wantBody, tt.wantEncoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package alb
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// remoteAddr derives the client address from the X-Forwarded-For and
+// X-Forwarded-Port headers injected by ALB.
+//
+// ALB appends the address of the peer it received the connection from as the
+// rightmost X-Forwarded-For entry, anything to the left of it is supplied by
+// the client or by proxies in front of ALB and cannot be trusted beyond the
+// configured number of hops. If the chain is shorter than hops+1, every entry
+// was added by a trusted party and the leftmost one is used.
+//
+// The port is taken from the selected entry if it carries one (ALB does so
+// when routing.http.xff_client_port.enabled is set), otherwise from
+// X-Forwarded-Port. An empty string is returned if no valid address can be
+// found.
+func remoteAddr(h http.Header, hops int) string {
+	var entries []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				entries = append(entries, s)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	i := len(entries) - 1 - hops
+	if i < 0 {
+		i = 0
+	}
+	ip, port := splitForwardedAddr(entries[i])
+	if ip == "" {
+		return ""
+	}
+	if port == "" {
+		port = strings.TrimSpace(h.Get("X-Forwarded-Port"))
+	}
+	if !validPort(port) {
+		return ip
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// splitForwardedAddr parses a single X-Forwarded-For entry, which is either a
+// bare IP address or an address with a port ("192.0.2.1:1234",
+// "[2001:db8::1]:1234"). It returns empty ip if the entry is malformed.
+func splitForwardedAddr(s string) (ip, port string) {
+	if addr := net.ParseIP(s); addr != nil {
+		return addr.String(), ""
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", ""
+	}
+	addr := net.ParseIP(host)
+	if addr == nil || !validPort(port) {
+		return "", ""
+	}
+	return addr.String(), port
+}
+
+func validPort(s string) bool {
+	if len(s) == 0 || len(s) > 5 {
+		return false
+	}
+	var n int
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		n = n*10 + int(s[i]-'0')
+	}
+	return n <= 65535
+}
+
+// forwardedProto returns the scheme the client used to connect to ALB as
+// reported by X-Forwarded-Proto, or an empty string if the header is missing
+// or has an unexpected value.
+func forwardedProto(h http.Header) string {
+	v := h.Get("X-Forwarded-Proto")
+	if i := strings.LastIndexByte(v, ','); i >= 0 {
+		v = v[i+1:]
+	}
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "http", "https":
+		return v
+	}
+	return ""
+}
+
+// forwardedTLS synthesizes connection state of the client connection
+// terminated by ALB. Version and cipher suite are only known if the target
+// group has routing.http.x_amzn_tls_version_and_cipher_suite.enabled
+// attribute set, otherwise they are left zero.
+func forwardedTLS(h http.Header, host string) *tls.ConnectionState {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return &tls.ConnectionState{
+		HandshakeComplete: true,
+		ServerName:        host,
+		Version:           tlsVersions[strings.TrimSpace(h.Get("X-Amzn-Tls-Version"))],
+		CipherSuite:       cipherSuite(strings.TrimSpace(h.Get("X-Amzn-Tls-Cipher-Suite"))),
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"TLSv1":   tls.VersionTLS10,
+	"TLSv1.1": tls.VersionTLS11,
+	"TLSv1.2": tls.VersionTLS12,
+	"TLSv1.3": tls.VersionTLS13,
+}
+
+// opensslCipherSuites maps OpenSSL cipher names used by ALB security policies
+// to their IANA counterparts known to crypto/tls.
+var opensslCipherSuites = map[string]uint16{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES128-GCM-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-ECDSA-AES128-SHA256":     tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-RSA-AES128-SHA256":       tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"ECDHE-ECDSA-AES128-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-RSA-AES128-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-AES256-GCM-SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-AES256-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-RSA-AES256-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	"ECDHE-RSA-CHACHA20-POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	"AES128-GCM-SHA256":             tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"AES128-SHA256":                 tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"AES128-SHA":                    tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"AES256-GCM-SHA384":             tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"AES256-SHA":                    tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// cipherSuite resolves cipher suite name as reported by ALB, which uses
+// OpenSSL names for TLS 1.2 and below and IANA names for TLS 1.3. It returns
+// 0 for unknown names.
+func cipherSuite(name string) uint16 {
+	if id, ok := opensslCipherSuites[name]; ok {
+		return id
+	}
+	if name == "" {
+		return 0
+	}
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return s.ID
+		}
+	}
+	return 0
+}
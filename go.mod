@@ -1 +1,3 @@
 module github.com/MichaelFraser99/alb
+
+go 1.22
@@ -0,0 +1,174 @@
+package alb
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderPolicy defines how response headers that must not be comma-joined,
+// such as Set-Cookie, are sent when the target group has multi-value headers
+// disabled and handler sets several values for them.
+type HeaderPolicy int
+
+const (
+	// PermuteHeaderCase sends every value under a differently cased header
+	// name ("Set-Cookie", "set-Cookie", "sEt-Cookie", ...), which clients
+	// treat as separate headers of the same name.
+	PermuteHeaderCase HeaderPolicy = iota
+	// FirstHeaderValue sends only the first value, the rest are dropped and
+	// logged.
+	FirstHeaderValue
+	// JoinHeaderValues comma-joins values as done for any other header. This
+	// corrupts cookies with Expires attribute.
+	JoinHeaderValues
+)
+
+// WithHeaderPolicy sets how response headers that must not be comma-joined
+// are sent in single-value headers mode. The default is PermuteHeaderCase.
+func WithHeaderPolicy(p HeaderPolicy) Option {
+	return func(h *lambdaHandler) { h.headerPolicy = p }
+}
+
+// nonCombinableHeaders lists canonical names of headers whose values cannot
+// be reliably joined with commas, as the values may contain commas
+// themselves.
+var nonCombinableHeaders = map[string]bool{
+	"Set-Cookie":         true,
+	"Www-Authenticate":   true,
+	"Proxy-Authenticate": true,
+}
+
+// singleValueHeaders converts header to the form used when the target group
+// has multi-value headers disabled. It returns non-nil error describing
+// header values that could not be sent.
+func singleValueHeaders(header map[string][]string, policy HeaderPolicy) (map[string]string, error) {
+	out := make(map[string]string, len(header))
+	var lost []string
+	for k, vv := range header {
+		if len(vv) < 2 || policy == JoinHeaderValues || !nonCombinableHeaders[textproto.CanonicalMIMEHeaderKey(k)] {
+			out[k] = strings.Join(vv, ",")
+			continue
+		}
+		var n int
+		switch policy {
+		case PermuteHeaderCase:
+			for ; n < len(vv); n++ {
+				key, ok := permuteCase(k, n)
+				if !ok {
+					break
+				}
+				out[key] = vv[n]
+			}
+		default:
+			out[k], n = vv[0], 1
+		}
+		if n < len(vv) {
+			lost = append(lost, fmt.Sprintf("%s (%d of %d values)", k, len(vv)-n, len(vv)))
+		}
+	}
+	if len(lost) != 0 {
+		return out, fmt.Errorf("alb: response header values dropped in single-value headers mode: %s", strings.Join(lost, ", "))
+	}
+	return out, nil
+}
+
+// permuteCase returns n-th case permutation of s: letter case is flipped for
+// every letter whose index among letters of s has its bit set in n. It
+// returns false if s does not have enough letters for n permutations.
+func permuteCase(s string, n int) (string, bool) {
+	if n == 0 {
+		return s, true
+	}
+	b := []byte(s)
+	for i := range b {
+		if n == 0 {
+			break
+		}
+		c := b[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+			c -= 'a' - 'A'
+		case 'A' <= c && c <= 'Z':
+			c += 'a' - 'A'
+		default:
+			continue
+		}
+		if n&1 == 1 {
+			b[i] = c
+		}
+		n >>= 1
+	}
+	if n != 0 {
+		return "", false
+	}
+	return string(b), true
+}
+
+// HeaderMode describes whether the target group has multi-value headers
+// enabled with the lambda.multi_value_headers.enabled attribute.
+type HeaderMode int
+
+const (
+	// DetectHeaders infers header mode from the shape of every event.
+	DetectHeaders HeaderMode = iota
+	// SingleValueHeaders means multi-value headers are disabled, events carry
+	// headers and queryStringParameters fields.
+	SingleValueHeaders
+	// MultiValueHeaders means multi-value headers are enabled, events carry
+	// multiValueHeaders and multiValueQueryStringParameters fields.
+	MultiValueHeaders
+)
+
+func (m HeaderMode) String() string {
+	switch m {
+	case DetectHeaders:
+		return "auto-detected headers"
+	case SingleValueHeaders:
+		return "single-value headers"
+	case MultiValueHeaders:
+		return "multi-value headers"
+	}
+	return fmt.Sprintf("HeaderMode(%d)", int(m))
+}
+
+// WithHeaderMode declares header mode of the target group invoking the
+// function. Events of a different shape are still handled according to
+// their shape, as that is what ALB expects in response, but a diagnostic is
+// logged for each of them: such mismatch usually means the target group
+// attribute was changed without redeploying the function. Events without
+// any header or query fields are handled according to the declared mode.
+//
+// The default is DetectHeaders, which handles every event according to its
+// shape, and falls back to single-value headers if it cannot be told.
+func WithHeaderMode(m HeaderMode) Option {
+	return func(h *lambdaHandler) { h.headerMode = m }
+}
+
+// headerMode returns header mode event shape corresponds to, or
+// DetectHeaders if it has neither single- nor multi-value fields.
+func (r *Event) headerMode() HeaderMode {
+	switch {
+	case r.MultiValueHeaders != nil || r.MultiValueQuery != nil:
+		return MultiValueHeaders
+	case r.Headers != nil || r.Query != nil:
+		return SingleValueHeaders
+	}
+	return DetectHeaders
+}
+
+// eventHeaderMode returns header mode response to req should be sent in,
+// logging events contradicting the configured mode.
+func (h *lambdaHandler) eventHeaderMode(req *Event) HeaderMode {
+	m := req.headerMode()
+	switch {
+	case m == DetectHeaders && h.headerMode == MultiValueHeaders:
+		return MultiValueHeaders
+	case m == DetectHeaders:
+		return SingleValueHeaders
+	case h.headerMode != DetectHeaders && m != h.headerMode:
+		h.logf("alb: handler is configured for %v, but event has %v; check lambda.multi_value_headers.enabled attribute of target group %s",
+			h.headerMode, m, req.RequestContext.ELB.TargetGroupARN)
+	}
+	return m
+}
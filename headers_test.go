@@ -0,0 +1,184 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLambdaHandler_NonCombinableHeaders(t *testing.T) {
+	cookies := []string{
+		"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Path=/",
+		"b=2; Expires=Thu, 22 Oct 2015 07:28:00 GMT; Path=/",
+		"c=3",
+	}
+	tests := []struct {
+		name        string
+		opts        []Option
+		multiValue  bool
+		header      http.Header
+		wantHeaders map[string]string
+		wantMulti   map[string][]string
+		wantLog     string
+	}{
+		{
+			name:   "cookies case permuted",
+			header: http.Header{"Set-Cookie": cookies},
+			wantHeaders: map[string]string{
+				"Set-Cookie": cookies[0],
+				"set-Cookie": cookies[1],
+				"SEt-Cookie": cookies[2],
+			},
+		},
+		{
+			name:        "single cookie untouched",
+			header:      http.Header{"Set-Cookie": cookies[:1]},
+			wantHeaders: map[string]string{"Set-Cookie": cookies[0]},
+		},
+		{
+			name: "authenticate challenges case permuted",
+			header: http.Header{
+				"Www-Authenticate": {`Basic realm="a, b"`, `Bearer realm="c"`},
+				"X-Multi":          {"first", "second"},
+			},
+			wantHeaders: map[string]string{
+				"Www-Authenticate": `Basic realm="a, b"`,
+				"www-Authenticate": `Bearer realm="c"`,
+				"X-Multi":          "first,second",
+			},
+		},
+		{
+			name:        "first value kept",
+			opts:        []Option{WithHeaderPolicy(FirstHeaderValue)},
+			header:      http.Header{"Set-Cookie": cookies},
+			wantHeaders: map[string]string{"Set-Cookie": cookies[0]},
+			wantLog:     "Set-Cookie (2 of 3 values)",
+		},
+		{
+			name:        "values joined",
+			opts:        []Option{WithHeaderPolicy(JoinHeaderValues)},
+			header:      http.Header{"Set-Cookie": cookies[:2]},
+			wantHeaders: map[string]string{"Set-Cookie": cookies[0] + "," + cookies[1]},
+		},
+		{
+			name:       "multi-value mode untouched",
+			multiValue: true,
+			header:     http.Header{"Set-Cookie": cookies},
+			wantMulti:  map[string][]string{"Set-Cookie": cookies},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := append([]Option{WithLogger(log.New(&buf, "", 0))}, tt.opts...)
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, vv := range tt.header {
+					w.Header()[k] = vv
+				}
+			}), opts...)
+			req := Event{Method: "GET", Path: "/"}
+			if tt.multiValue {
+				req.MultiValueHeaders = map[string][]string{}
+			}
+			resp, err := fn(context.Background(), req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantHeaders != nil {
+				delete(resp.Headers, "Content-Type")
+				if !reflect.DeepEqual(resp.Headers, tt.wantHeaders) {
+					t.Errorf("Headers = %q, want %q", resp.Headers, tt.wantHeaders)
+				}
+			}
+			if tt.wantMulti != nil {
+				delete(resp.MultiValueHeaders, "Content-Type")
+				if !reflect.DeepEqual(resp.MultiValueHeaders, tt.wantMulti) {
+					t.Errorf("MultiValueHeaders = %q, want %q", resp.MultiValueHeaders, tt.wantMulti)
+				}
+			}
+			if tt.wantLog == "" && buf.Len() != 0 {
+				t.Errorf("unexpected log output: %q", buf.String())
+			}
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Errorf("log = %q, want it to contain %q", buf.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestPermuteCase(t *testing.T) {
+	seen := make(map[string]bool)
+	for n := 0; n < 1<<6; n++ {
+		got, ok := permuteCase("X-a-B1c-d-E", n)
+		if !ok {
+			t.Fatalf("permuteCase(%d) failed", n)
+		}
+		if !strings.EqualFold(got, "X-a-B1c-d-E") {
+			t.Errorf("permuteCase(%d) = %q changed header name", n, got)
+		}
+		if seen[got] {
+			t.Errorf("permuteCase(%d) = %q is a duplicate", n, got)
+		}
+		seen[got] = true
+	}
+	if _, ok := permuteCase("X-a-B1c-d-E", 1<<6); ok {
+		t.Error("permuteCase succeeded past the number of permutations")
+	}
+	if got, _ := permuteCase("Set-Cookie", 0); got != "Set-Cookie" {
+		t.Errorf("permuteCase(0) = %q, want name unchanged", got)
+	}
+}
+
+func TestLambdaHandler_HeaderMode(t *testing.T) {
+	single := Event{Method: "GET", Path: "/", Headers: map[string]string{}, Query: map[string]string{}}
+	multi := Event{Method: "GET", Path: "/", MultiValueHeaders: map[string][]string{}, MultiValueQuery: map[string][]string{}}
+	empty := Event{Method: "GET", Path: "/"}
+
+	tests := []struct {
+		name      string
+		mode      HeaderMode
+		req       Event
+		wantMulti bool
+		wantLog   bool
+	}{
+		{name: "detect single", req: single},
+		{name: "detect multi", req: multi, wantMulti: true},
+		{name: "detect empty falls back to single", req: empty},
+		{name: "declared single matches", mode: SingleValueHeaders, req: single},
+		{name: "declared multi matches", mode: MultiValueHeaders, req: multi, wantMulti: true},
+		{name: "declared multi used for empty event", mode: MultiValueHeaders, req: empty, wantMulti: true},
+		{name: "declared single used for empty event", mode: SingleValueHeaders, req: empty},
+		{name: "declared single contradicted", mode: SingleValueHeaders, req: multi, wantMulti: true, wantLog: true},
+		{name: "declared multi contradicted", mode: MultiValueHeaders, req: single, wantLog: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "1")
+			}), WithHeaderMode(tt.mode), WithLogger(log.New(&buf, "", 0)))
+			resp, err := fn(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantMulti {
+				if resp.Headers != nil || !reflect.DeepEqual(resp.MultiValueHeaders["X-Test"], []string{"1"}) {
+					t.Errorf("want multi-value headers, got Headers=%v MultiValueHeaders=%v", resp.Headers, resp.MultiValueHeaders)
+				}
+			} else {
+				if resp.MultiValueHeaders != nil || resp.Headers["X-Test"] != "1" {
+					t.Errorf("want single-value headers, got Headers=%v MultiValueHeaders=%v", resp.Headers, resp.MultiValueHeaders)
+				}
+			}
+			if gotLog := strings.Contains(buf.String(), "lambda.multi_value_headers.enabled"); gotLog != tt.wantLog {
+				t.Errorf("diagnostic logged = %v, want %v, log: %q", gotLog, tt.wantLog, buf.String())
+			}
+		})
+	}
+}
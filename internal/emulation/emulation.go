@@ -0,0 +1,40 @@
+// Package emulation holds hooks into the handler returned by alb.Emulate,
+// shared with package albtest, which builds on it.
+package emulation
+
+import (
+	"context"
+	"net/http"
+)
+
+// TargetGroupARN is the target group ARN set in events built by the
+// emulator.
+const TargetGroupARN = "arn:aws:elasticloadbalancing:local:000000000000:targetgroup/alb-emulator/0000000000000000"
+
+// Trace is a set of hooks run by the emulator, for tests to adjust requests
+// and inspect the raw JSON exchanged with the function. Any of them may be
+// nil.
+type Trace struct {
+	// Forwarded is called with request header once the headers ALB adds
+	// are set, before the event is built. It may modify the header.
+	Forwarded func(http.Header)
+
+	// Invoked is called with the event and response JSON once the function
+	// returns. The response is nil if the invocation failed with err.
+	Invoked func(event, response []byte, err error)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a new context based on ctx, requests with which have the
+// hooks of trace run by the emulator.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// ContextTrace returns the Trace associated with ctx, or nil if there is
+// none.
+func ContextTrace(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceKey{}).(*Trace)
+	return trace
+}
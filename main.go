@@ -0,0 +1,108 @@
+package alb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// DefaultAddr is the address Main serves HTTP on if PORT is not set. It
+// listens on all interfaces, so that the server is reachable from outside
+// a container.
+const DefaultAddr = ":8080"
+
+// Main runs h in the mode chosen by the environment, options are applied
+// as with Handler:
+//
+//   - inside AWS Lambda, where AWS_LAMBDA_RUNTIME_API is set, it runs h
+//     with Start;
+//   - if PORT is set, it serves HTTP on that port with ListenAndServe;
+//   - if standard input is a pipe or a file, it reads ALB event JSON
+//     documents from it, and prints response JSON of each to standard
+//     output on a line of its own. Failed invocations are printed as Lambda
+//     reports them, {"errorMessage":"...","errorType":"..."}, and make the
+//     process exit with status 1 once all events are handled;
+//   - otherwise, as with standard input of containers and systemd units
+//     being a terminal or /dev/null, it serves HTTP on DefaultAddr, port
+//     8080 of all interfaces, with ListenAndServe.
+//
+// Main never returns.
+func Main(h http.Handler, opts ...Option) {
+	switch mode, addr := detectMode(os.Getenv, os.Stdin); mode {
+	case lambdaMode:
+		Start(h, opts...)
+	case httpMode:
+		log.Printf("alb: serving HTTP on %s", addr)
+		log.Fatal(ListenAndServe(addr, h, opts...))
+	case eventsMode:
+		if h == nil {
+			log.Fatal("alb: Main called with nil handler")
+		}
+		failed, err := serveEvents(os.Stdin, os.Stdout, newLambdaHandler(h, opts))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
+
+type runMode int
+
+const (
+	lambdaMode runMode = iota
+	httpMode
+	eventsMode
+)
+
+// detectMode chooses mode Main runs in and the address to serve HTTP on.
+func detectMode(getenv func(string) string, stdin *os.File) (runMode, string) {
+	if getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		return lambdaMode, ""
+	}
+	if port := getenv("PORT"); port != "" {
+		return httpMode, ":" + port
+	}
+	if fi, err := stdin.Stat(); err == nil && (fi.Mode()&os.ModeNamedPipe != 0 || fi.Mode().IsRegular()) {
+		return eventsMode, ""
+	}
+	return httpMode, DefaultAddr
+}
+
+// serveEvents handles stream of event JSON documents read from r as the
+// runtime started with Start would, writing response of each to w. It
+// reports whether any invocation failed; returned error means the stream
+// could not be read or the output written.
+func serveEvents(r io.Reader, w io.Writer, h *lambdaHandler) (failed bool, err error) {
+	h.rawJSON = true
+	dec := json.NewDecoder(r)
+	for {
+		var payload json.RawMessage
+		if err := dec.Decode(&payload); err != nil {
+			if errors.Is(err, io.EOF) {
+				return failed, nil
+			}
+			return failed, fmt.Errorf("alb: reading events: %w", err)
+		}
+		var out []byte
+		var req Event
+		if err := decodeEvent(payload, &req); err != nil {
+			out, failed = errorJSON("Runtime.UnmarshalError", fmt.Errorf("decoding event: %w", err)), true
+		} else if resp, err := h.run(context.Background(), req); err != nil {
+			out, failed = errorJSON(errorType(err), err), true
+		} else {
+			out = appendResponse(make([]byte, 0, resp.size()+1), resp)
+			resp.release()
+		}
+		if _, err := w.Write(append(out, '\n')); err != nil {
+			return failed, err
+		}
+	}
+}
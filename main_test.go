@@ -0,0 +1,108 @@
+package alb
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectMode(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	file, err := os.Create(filepath.Join(t.TempDir(), "events.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		stdin    *os.File
+		wantMode runMode
+		wantAddr string
+	}{
+		{"lambda", map[string]string{"AWS_LAMBDA_RUNTIME_API": "127.0.0.1:9001", "PORT": "3000"}, pr, lambdaMode, ""},
+		{"port", map[string]string{"PORT": "3000"}, pr, httpMode, ":3000"},
+		{"pipe", nil, pr, eventsMode, ""},
+		{"file", nil, file, eventsMode, ""},
+		{"no input", nil, devNull, httpMode, DefaultAddr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, addr := detectMode(func(k string) string { return tt.env[k] }, tt.stdin)
+			if mode != tt.wantMode || addr != tt.wantAddr {
+				t.Errorf("got %v %q, want %v %q", mode, addr, tt.wantMode, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	h := newLambdaHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello <"+r.URL.Path+">")
+	}), []Option{WithHeaderMode(MultiValueHeaders), WithLogger(log.New(io.Discard, "", 0))})
+	in := `{"httpMethod":"GET","path":"/a","multiValueHeaders":{}}
+	{"httpMethod":"GET","path":"/abort"}
+	[1]
+	{"httpMethod":"GET","path":"/b","headers":{}}`
+	var out bytes.Buffer
+	failed, err := serveEvents(strings.NewReader(in), &out, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !failed {
+		t.Error("failed invocations not reported")
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4:\n%s", len(lines), out.String())
+	}
+	for i, want := range []string{"hello </a>", "", "", "hello </b>"} {
+		var resp Response
+		if err := json.Unmarshal([]byte(lines[i]), &resp); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if resp.Body != want {
+			t.Errorf("line %d: body %q, want %q", i, resp.Body, want)
+		}
+	}
+	if !strings.Contains(lines[0], `"multiValueHeaders":{"Content-Type":["text/plain"]}`) {
+		t.Errorf("unexpected response: %s", lines[0])
+	}
+	if !strings.Contains(lines[3], `"headers":{"Content-Type":"text/plain"}`) {
+		t.Errorf("unexpected response: %s", lines[3])
+	}
+	for i, want := range []string{`"errorType":"errorString"`, `"errorType":"Runtime.UnmarshalError"`} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("line %d: %s does not contain %s", i+1, lines[i+1], want)
+		}
+	}
+
+	out.Reset()
+	if _, err := serveEvents(strings.NewReader(`{"httpMethod":"GET","path":"/"} {`), &out, h); err == nil {
+		t.Error("no error for truncated stream")
+	}
+	if n := strings.Count(out.String(), "\n"); n != 1 {
+		t.Errorf("got %d responses before truncated event, want 1", n)
+	}
+}
@@ -0,0 +1,107 @@
+package alb
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// ClientCertPolicy defines how requests with client certificate headers that
+// cannot be parsed are handled.
+type ClientCertPolicy int
+
+const (
+	// RejectInvalidClientCert responds with 400 Bad Request without calling
+	// the wrapped handler.
+	RejectInvalidClientCert ClientCertPolicy = iota
+	// IgnoreInvalidClientCert calls the wrapped handler with request TLS
+	// state removed, as if the connection was not secured at all.
+	IgnoreInvalidClientCert
+)
+
+// MutualTLSMode is the mutual TLS mode of the ALB listener, which decides
+// the client certificate header ALB forwards.
+type MutualTLSMode int
+
+const (
+	// MTLSOff means the listener has mutual TLS disabled. Client certificate
+	// headers are not parsed, as ALB passes them from the client as is.
+	MTLSOff MutualTLSMode = iota
+	// MTLSVerify means ALB verifies client certificates against its trust
+	// store and forwards the leaf certificate in X-Amzn-Mtls-Clientcert-Leaf.
+	MTLSVerify
+	// MTLSPassthrough means ALB forwards the whole client certificate chain
+	// in X-Amzn-Mtls-Clientcert without verifying it.
+	MTLSPassthrough
+)
+
+// WithMutualTLS declares mutual TLS mode of the listener, enabling parsing of
+// the client certificate header ALB forwards in that mode into
+// r.TLS.PeerCertificates. The header of the other mode is ignored, as the
+// client may have sent it. Since ALB does not forward the chain it verified
+// the leaf against, r.TLS.VerifiedChains is never set; in verify mode ALB
+// rejects connections with certificates failing verification. The default is
+// MTLSOff.
+//
+// Certificates are only parsed for requests that have r.TLS set, which
+// requires WithForwardedProto enabled.
+func WithMutualTLS(m MutualTLSMode) Option {
+	return func(h *lambdaHandler) { h.mtlsMode = m }
+}
+
+// setClientCertificates fills state.PeerCertificates from the client
+// certificate header ALB forwards in given mutual TLS mode. It does nothing
+// if the header is not present.
+func setClientCertificates(state *tls.ConnectionState, h http.Header, mode MutualTLSMode) error {
+	var v string
+	switch mode {
+	case MTLSVerify:
+		v = h.Get("X-Amzn-Mtls-Clientcert-Leaf")
+	case MTLSPassthrough:
+		v = h.Get("X-Amzn-Mtls-Clientcert")
+	}
+	if v == "" {
+		return nil
+	}
+	certs, err := parseCertificates(v)
+	if err != nil {
+		return err
+	}
+	state.PeerCertificates = certs
+	return nil
+}
+
+// parseCertificates decodes URL-encoded PEM certificate chain.
+func parseCertificates(s string) ([]*x509.Certificate, error) {
+	s, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("unexpected PEM block type " + block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, errors.New("trailing data after PEM certificates")
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
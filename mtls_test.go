@@ -0,0 +1,215 @@
+package alb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLambdaHandler_ClientCertificates(t *testing.T) {
+	ca, caDER := newTestCert(t, "Test CA", nil)
+	_, leafDER := newTestCert(t, "client.example.com", ca)
+	chain := url.PathEscape(string(pemCert(leafDER)) + string(pemCert(caDER)))
+	leaf := url.PathEscape(string(pemCert(leafDER)))
+
+	verify := []Option{WithMutualTLS(MTLSVerify)}
+	passthrough := []Option{WithMutualTLS(MTLSPassthrough)}
+	tests := []struct {
+		name         string
+		opts         []Option
+		headers      map[string]string
+		wantStatus   int
+		wantTLS      bool
+		wantSubjects []string
+	}{
+		{
+			name:       "no client certificate",
+			opts:       verify,
+			headers:    map[string]string{"x-forwarded-proto": "https"},
+			wantStatus: http.StatusOK,
+			wantTLS:    true,
+		},
+		{
+			name:       "headers ignored by default",
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert-leaf": leaf, "x-amzn-mtls-clientcert": chain},
+			wantStatus: http.StatusOK,
+			wantTLS:    true,
+		},
+		{
+			name:       "malformed headers ignored by default",
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": "garbage"},
+			wantStatus: http.StatusOK,
+			wantTLS:    true,
+		},
+		{
+			name:         "passthrough mode chain",
+			opts:         passthrough,
+			headers:      map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": chain},
+			wantStatus:   http.StatusOK,
+			wantTLS:      true,
+			wantSubjects: []string{"client.example.com", "Test CA"},
+		},
+		{
+			name:       "passthrough mode ignores leaf header",
+			opts:       passthrough,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert-leaf": leaf},
+			wantStatus: http.StatusOK,
+			wantTLS:    true,
+		},
+		{
+			name:         "verify mode leaf",
+			opts:         verify,
+			headers:      map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert-leaf": leaf},
+			wantStatus:   http.StatusOK,
+			wantTLS:      true,
+			wantSubjects: []string{"client.example.com"},
+		},
+		{
+			name:       "verify mode ignores chain header",
+			opts:       verify,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": chain},
+			wantStatus: http.StatusOK,
+			wantTLS:    true,
+		},
+		{
+			name:       "certificate ignored on plain http",
+			opts:       passthrough,
+			headers:    map[string]string{"x-forwarded-proto": "http", "x-amzn-mtls-clientcert": chain},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "certificate ignored without forwarded proto",
+			opts:       []Option{WithMutualTLS(MTLSPassthrough), WithForwardedProto(false)},
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": chain},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "malformed certificate rejected",
+			opts:       passthrough,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": "-----BEGIN%20CERTIFICATE-----%0AAAAA%0A-----END%20CERTIFICATE-----%0A"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid url encoding rejected",
+			opts:       passthrough,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": "%zz"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no PEM data rejected",
+			opts:       verify,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert-leaf": "garbage"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "trailing data rejected",
+			opts:       passthrough,
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": chain + "garbage"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed certificate passed through without TLS state",
+			opts:       []Option{WithMutualTLS(MTLSPassthrough), WithClientCertPolicy(IgnoreInvalidClientCert)},
+			headers:    map[string]string{"x-forwarded-proto": "https", "x-amzn-mtls-clientcert": "garbage"},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			var gotTLS bool
+			var gotSubjects []string
+			var gotVerified bool
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				if r.TLS == nil {
+					return
+				}
+				gotTLS = true
+				for _, c := range r.TLS.PeerCertificates {
+					gotSubjects = append(gotSubjects, c.Subject.CommonName)
+				}
+				gotVerified = len(r.TLS.VerifiedChains) != 0
+			}), tt.opts...)
+
+			resp, err := fn(context.Background(), Event{
+				Method:  "GET",
+				Path:    "/",
+				Headers: tt.headers,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v, want %v", called, !called)
+			}
+			if gotTLS != tt.wantTLS {
+				t.Errorf("TLS present = %v, want %v", gotTLS, tt.wantTLS)
+			}
+			if len(gotSubjects) != len(tt.wantSubjects) {
+				t.Fatalf("PeerCertificates subjects = %v, want %v", gotSubjects, tt.wantSubjects)
+			}
+			for i := range gotSubjects {
+				if gotSubjects[i] != tt.wantSubjects[i] {
+					t.Errorf("PeerCertificates[%d] subject = %q, want %q", i, gotSubjects[i], tt.wantSubjects[i])
+				}
+			}
+			if gotVerified {
+				t.Error("VerifiedChains set")
+			}
+		})
+	}
+}
+
+// newTestCert creates a certificate with given common name, self-signed if
+// parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert) (*testCert, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}, der
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func pemCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,391 @@
+package alb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig configures verification of the user claims ALB forwards after
+// authenticate-oidc or authenticate-cognito listener rule actions.
+type OIDCConfig struct {
+	// Signers lists ARNs of load balancers allowed to sign tokens. Tokens
+	// signed by any other load balancer are rejected. Required.
+	Signers []string
+
+	// Keys is used to fetch public keys tokens are signed with. Fetched keys
+	// are cached for the lifetime of the handler returned by RequireOIDC.
+	// Failed fetches are retried after a second, doubling up to a minute
+	// while they keep failing. Keys not seen before are fetched at most
+	// once a second in each region, tokens needing another one meanwhile
+	// are rejected. If nil, &ALBKeyFetcher{} is used.
+	Keys KeyFetcher
+
+	// Unauthorized is called for requests failing verification. If nil,
+	// plain text 401 Unauthorized response is sent.
+	Unauthorized func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// OIDCIdentity holds verified user claims forwarded by ALB.
+type OIDCIdentity struct {
+	Subject     string                 // "sub" claim, same as x-amzn-oidc-identity header
+	Issuer      string                 // "iss" claim
+	Expires     time.Time              // "exp" claim
+	AccessToken string                 // x-amzn-oidc-accesstoken header, unverified
+	Claims      map[string]interface{} // all claims from the token payload
+}
+
+// OIDCFromContext returns identity verified by the handler returned by
+// RequireOIDC.
+func OIDCFromContext(ctx context.Context) (*OIDCIdentity, bool) {
+	id, ok := ctx.Value(oidcKey{}).(*OIDCIdentity)
+	return id, ok
+}
+
+type oidcKey struct{}
+
+// WithOIDC wraps handler with RequireOIDC.
+func WithOIDC(cfg OIDCConfig) Option {
+	return func(h *lambdaHandler) { h.handler = RequireOIDC(h.handler, cfg) }
+}
+
+// RequireOIDC returns handler that verifies ES256 signed token ALB passes in
+// x-amzn-oidc-data header: its signature, signer and expiration time. Only
+// requests passing verification reach h, the verified claims are available
+// to it with OIDCFromContext.
+func RequireOIDC(h http.Handler, cfg OIDCConfig) http.Handler {
+	if h == nil {
+		panic("RequireOIDC called with nil handler")
+	}
+	if len(cfg.Signers) == 0 {
+		panic("RequireOIDC called with empty OIDCConfig.Signers")
+	}
+	v := &oidcVerifier{
+		handler:      h,
+		signers:      make(map[string]struct{}, len(cfg.Signers)),
+		keys:         cfg.Keys,
+		unauthorized: cfg.Unauthorized,
+		cache:        make(map[string]*ecdsa.PublicKey),
+		fetches:      make(map[string]*keyFetch),
+		nextFetch:    make(map[string]time.Time),
+	}
+	for _, s := range cfg.Signers {
+		v.signers[s] = struct{}{}
+	}
+	if v.keys == nil {
+		v.keys = &ALBKeyFetcher{}
+	}
+	if v.unauthorized == nil {
+		v.unauthorized = unauthorized
+	}
+	return v
+}
+
+func unauthorized(w http.ResponseWriter, _ *http.Request, _ error) {
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// KeyFetcher fetches public keys ALB signs user claims with.
+type KeyFetcher interface {
+	// FetchKey returns public key with given id used by load balancers in
+	// given region.
+	FetchKey(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error)
+}
+
+// ALBKeyFetcher fetches keys from the regional ALB public key endpoint.
+type ALBKeyFetcher struct {
+	// Client is used to make requests, http.DefaultClient if nil.
+	Client *http.Client
+	// Endpoint is the base URL keys are fetched from, "{region}" in it is
+	// replaced with the region name. Defaults to
+	// "https://public-keys.auth.elb.{region}.amazonaws.com/".
+	Endpoint string
+}
+
+// FetchKey implements KeyFetcher.
+func (f *ALBKeyFetcher) FetchKey(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error) {
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = "https://public-keys.auth.elb.{region}.amazonaws.com/"
+	}
+	endpoint = strings.Replace(endpoint, "{region}", region, -1)
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint+url.PathEscape(keyID), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching public key %q: unexpected status %s", keyID, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("fetching public key %q: no PEM data found", keyID)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("fetching public key %q: not a P-256 ECDSA key", keyID)
+	}
+	return pub, nil
+}
+
+type oidcVerifier struct {
+	handler      http.Handler
+	signers      map[string]struct{}
+	keys         KeyFetcher
+	unauthorized func(http.ResponseWriter, *http.Request, error)
+
+	mu        sync.Mutex
+	cache     map[string]*ecdsa.PublicKey // keyed by region and key id
+	fetches   map[string]*keyFetch        // in flight and failed, keyed as cache
+	nextFetch map[string]time.Time        // when a key not seen before may be fetched, by region
+}
+
+func (v *oidcVerifier) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, err := v.verify(r)
+	if err != nil {
+		v.unauthorized(w, r, err)
+		return
+	}
+	v.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oidcKey{}, id)))
+}
+
+func (v *oidcVerifier) verify(r *http.Request) (*OIDCIdentity, error) {
+	token := r.Header.Get("X-Amzn-Oidc-Data")
+	if token == "" {
+		return nil, errors.New("missing x-amzn-oidc-data header")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var hdr struct {
+		Alg    string `json:"alg"`
+		Kid    string `json:"kid"`
+		Signer string `json:"signer"`
+		Exp    int64  `json:"exp"`
+	}
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	if hdr.Alg != "ES256" {
+		return nil, fmt.Errorf("unexpected token algorithm %q", hdr.Alg)
+	}
+	if _, ok := v.signers[hdr.Signer]; !ok {
+		return nil, fmt.Errorf("unexpected token signer %q", hdr.Signer)
+	}
+	region, err := arnRegion(hdr.Signer)
+	if err != nil {
+		return nil, err
+	}
+	key, err := v.key(r.Context(), region, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil || len(sig) != 64 {
+		return nil, errors.New("malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, errors.New("invalid token signature")
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token payload: %w", err)
+	}
+	id := &OIDCIdentity{
+		AccessToken: r.Header.Get("X-Amzn-Oidc-Accesstoken"),
+		Claims:      claims,
+	}
+	id.Subject, _ = claims["sub"].(string)
+	id.Issuer, _ = claims["iss"].(string)
+	exp, _ := claims["exp"].(float64)
+	if exp == 0 {
+		exp = float64(hdr.Exp)
+	}
+	if exp == 0 {
+		return nil, errors.New("token has no expiration time")
+	}
+	id.Expires = time.Unix(int64(exp), 0)
+	if !time.Now().Before(id.Expires) {
+		return nil, errors.New("token expired")
+	}
+	if s := r.Header.Get("X-Amzn-Oidc-Identity"); s != "" && s != id.Subject {
+		return nil, errors.New("x-amzn-oidc-identity header does not match token subject")
+	}
+	return id, nil
+}
+
+// Key ids come from the unauthenticated token header, so key fetches are
+// bounded: key ids must look like ones ALB issues, concurrent requests for
+// the same key share a fetch, failed fetches are retried with exponential
+// backoff, at most maxKeyFetches fetches are remembered, and fetches of
+// keys not seen before are started at most once per keyFetchInterval in
+// each region.
+const (
+	maxKeyIDLen      = 128
+	maxKeyFetches    = 1024
+	keyFetchInterval = time.Second
+	keyRetryMin      = time.Second
+	keyRetryMax      = time.Minute
+)
+
+var errKeyFetchLimited = errors.New("public key fetch rate limited")
+
+type keyFetch struct {
+	done     chan struct{}
+	key      *ecdsa.PublicKey
+	err      error
+	failures int       // consecutive failed fetches of the key
+	retry    time.Time // when a failed fetch may be retried, zero while in flight
+}
+
+func (v *oidcVerifier) key(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	cacheKey := region + "/" + keyID
+	now := time.Now()
+	v.mu.Lock()
+	if key, ok := v.cache[cacheKey]; ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+	f, ok := v.fetches[cacheKey]
+	if ok && f.retry.IsZero() {
+		v.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.key, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if ok && now.Before(f.retry) {
+		v.mu.Unlock()
+		return nil, f.err
+	}
+	failures := 0
+	if ok {
+		failures = f.failures
+		delete(v.fetches, cacheKey)
+	}
+	if now.Before(v.nextFetch[region]) || !v.reserveFetch(now) {
+		v.mu.Unlock()
+		return nil, errKeyFetchLimited
+	}
+	v.nextFetch[region] = now.Add(keyFetchInterval)
+	f = &keyFetch{done: make(chan struct{})}
+	v.fetches[cacheKey] = f
+	v.mu.Unlock()
+
+	key, err := v.keys.FetchKey(ctx, region, keyID)
+	v.mu.Lock()
+	f.key, f.err = key, err
+	switch {
+	case err == nil:
+		v.cache[cacheKey] = key
+		delete(v.fetches, cacheKey)
+	case ctx.Err() != nil:
+		// the failure is the request's, not the key's
+		delete(v.fetches, cacheKey)
+	default:
+		f.failures = failures + 1
+		backoff := keyRetryMin
+		for i := 1; i < f.failures && backoff < keyRetryMax; i++ {
+			backoff *= 2
+		}
+		if backoff > keyRetryMax {
+			backoff = keyRetryMax
+		}
+		f.retry = time.Now().Add(backoff)
+	}
+	close(f.done)
+	v.mu.Unlock()
+	return key, err
+}
+
+// reserveFetch reports whether another fetch can be remembered, forgetting
+// failed ones that may be retried already if there are too many. It must
+// be called with v.mu held.
+func (v *oidcVerifier) reserveFetch(now time.Time) bool {
+	if len(v.fetches) < maxKeyFetches {
+		return true
+	}
+	for k, f := range v.fetches {
+		if !f.retry.IsZero() && !now.Before(f.retry) {
+			delete(v.fetches, k)
+		}
+	}
+	return len(v.fetches) < maxKeyFetches
+}
+
+// checkKeyID reports whether id is plausible ALB key id, a UUID in practice.
+func checkKeyID(id string) error {
+	if id == "" {
+		return errors.New("token has no key id")
+	}
+	if len(id) > maxKeyIDLen {
+		return errors.New("malformed token key id")
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("malformed token key id %q", id)
+		}
+	}
+	return nil
+}
+
+// decodeSegment decodes base64url-encoded JSON token segment. ALB is known to
+// pad segments, which is not allowed by RFC 7515, so padding is tolerated.
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// arnRegion extracts region from load balancer ARN.
+func arnRegion(arn string) (string, error) {
+	// arn:partition:elasticloadbalancing:region:account:loadbalancer/...
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[3] == "" {
+		return "", fmt.Errorf("malformed signer ARN %q", arn)
+	}
+	return parts[3], nil
+}
@@ -0,0 +1,360 @@
+package alb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testSigner = "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/test/50dc6c495c0c9188"
+
+func TestRequireOIDC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eu-west-1/key-1" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}))
+	defer srv.Close()
+
+	future := time.Now().Add(time.Minute).Unix()
+	validHeader := map[string]interface{}{"alg": "ES256", "kid": "key-1", "signer": testSigner, "exp": future}
+	validClaims := map[string]interface{}{"sub": "user-1", "email": "user@example.com", "iss": "https://idp.example.com", "exp": future}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		wantCode int
+	}{
+		{
+			name: "valid token",
+			headers: map[string]string{
+				"x-amzn-oidc-data":        signTestToken(t, key, validHeader, validClaims, false),
+				"x-amzn-oidc-identity":    "user-1",
+				"x-amzn-oidc-accesstoken": "access-token",
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "valid padded token",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, key, validHeader, validClaims, true),
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing token",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "malformed token",
+			headers:  map[string]string{"x-amzn-oidc-data": "not.a-token"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong signing key",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, otherKey, validHeader, validClaims, false),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "unexpected signer",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, key, map[string]interface{}{
+					"alg": "ES256", "kid": "key-1", "exp": future,
+					"signer": "arn:aws:elasticloadbalancing:eu-west-1:999999999999:loadbalancer/app/evil/1",
+				}, validClaims, false),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "unexpected algorithm",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, key, map[string]interface{}{
+					"alg": "none", "kid": "key-1", "signer": testSigner, "exp": future,
+				}, validClaims, false),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown key id",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, key, map[string]interface{}{
+					"alg": "ES256", "kid": "key-2", "signer": testSigner, "exp": future,
+				}, validClaims, false),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "expired token",
+			headers: map[string]string{
+				"x-amzn-oidc-data": signTestToken(t, key, validHeader, map[string]interface{}{
+					"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix(),
+				}, false),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "identity header mismatch",
+			headers: map[string]string{
+				"x-amzn-oidc-data":     signTestToken(t, key, validHeader, validClaims, false),
+				"x-amzn-oidc-identity": "user-2",
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "tampered payload",
+			headers: map[string]string{
+				"x-amzn-oidc-data": tamperTestToken(signTestToken(t, key, validHeader, validClaims, false)),
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := OIDCFromContext(r.Context())
+		if !ok {
+			t.Error("OIDCFromContext returned no identity")
+			return
+		}
+		if id.Subject != "user-1" || id.Issuer != "https://idp.example.com" || id.Claims["email"] != "user@example.com" {
+			t.Errorf("unexpected identity: %+v", id)
+		}
+		if id.Expires.Unix() != future {
+			t.Errorf("Expires = %v, want %v", id.Expires.Unix(), future)
+		}
+	}), WithOIDC(OIDCConfig{
+		Signers: []string{testSigner},
+		Keys:    &ALBKeyFetcher{Client: srv.Client(), Endpoint: srv.URL + "/{region}"},
+	}))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := fn(context.Background(), Event{Method: "GET", Path: "/", Headers: tt.headers})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("public key fetched %d times, want 1", n)
+	}
+}
+
+func TestRequireOIDC_Unauthorized(t *testing.T) {
+	var gotErr error
+	h := RequireOIDC(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called")
+	}), OIDCConfig{
+		Signers: []string{testSigner},
+		Keys: keyFetcherFunc(func(context.Context, string, string) (*ecdsa.PublicKey, error) {
+			t.Error("key must not be fetched")
+			return nil, nil
+		}),
+		Unauthorized: func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("StatusCode = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Error("custom Unauthorized handler not used")
+	}
+	if gotErr == nil {
+		t.Error("Unauthorized called with nil error")
+	}
+}
+
+func TestArnRegion(t *testing.T) {
+	if got, err := arnRegion(testSigner); err != nil || got != "eu-west-1" {
+		t.Errorf("arnRegion() = %q, %v, want %q", got, err, "eu-west-1")
+	}
+	for _, arn := range []string{"", "arn:aws:elasticloadbalancing", "arn:aws:elasticloadbalancing::123:loadbalancer/x"} {
+		if _, err := arnRegion(arn); err == nil {
+			t.Errorf("arnRegion(%q) returned no error", arn)
+		}
+	}
+}
+
+func TestOIDCVerifier_Key(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches int32
+	release := make(chan struct{})
+	v := RequireOIDC(http.NotFoundHandler(), OIDCConfig{
+		Signers: []string{testSigner},
+		Keys: keyFetcherFunc(func(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error) {
+			atomic.AddInt32(&fetches, 1)
+			if keyID != "key-1" {
+				return nil, errors.New("no such key")
+			}
+			<-release
+			return &key.PublicKey, nil
+		}),
+	}).(*oidcVerifier)
+	ctx := context.Background()
+
+	// concurrent requests for a key share one fetch
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			got, err := v.key(ctx, "eu-west-1", "key-1")
+			if err == nil && got != &key.PublicKey {
+				err = errors.New("wrong key")
+			}
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&fetches) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("key-1: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("key-1 fetched %d times, want 1", n)
+	}
+
+	// other keys of the region wait for the fetch interval
+	if _, err := v.key(ctx, "eu-west-1", "key-2"); err != errKeyFetchLimited {
+		t.Errorf("key-2 within fetch interval: err = %v, want %v", err, errKeyFetchLimited)
+	}
+	if _, err := v.key(ctx, "us-east-1", "key-2"); err == nil || err == errKeyFetchLimited {
+		t.Errorf("key-2 in other region: err = %v, want fetch error", err)
+	}
+
+	// failures are remembered until retried with backoff
+	delete(v.nextFetch, "eu-west-1")
+	for i := 0; i < 3; i++ {
+		if _, err := v.key(ctx, "eu-west-1", "key-2"); err == nil || err == errKeyFetchLimited {
+			t.Errorf("key-2: err = %v, want fetch error", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("keys fetched %d times, want 3", n)
+	}
+	f := v.fetches["eu-west-1/key-2"]
+	if d := time.Until(f.retry); d <= 0 || d > keyRetryMin {
+		t.Errorf("first retry in %v, want at most %v", d, keyRetryMin)
+	}
+	f.retry = time.Now()
+	delete(v.nextFetch, "eu-west-1")
+	v.key(ctx, "eu-west-1", "key-2")
+	f = v.fetches["eu-west-1/key-2"]
+	if d := time.Until(f.retry); f.failures != 2 || d <= keyRetryMin || d > 2*keyRetryMin {
+		t.Errorf("second retry after %d failures in %v, want within %v", f.failures, d, 2*keyRetryMin)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 4 {
+		t.Errorf("keys fetched %d times, want 4", n)
+	}
+
+	// remembered failures are bounded, expired ones are forgotten
+	for i := len(v.fetches); i < maxKeyFetches; i++ {
+		v.fetches[fmt.Sprintf("eu-west-1/junk-%d", i)] = &keyFetch{err: errors.New("no such key"), retry: time.Now().Add(time.Minute)}
+	}
+	delete(v.nextFetch, "eu-west-1")
+	if _, err := v.key(ctx, "eu-west-1", "key-3"); err != errKeyFetchLimited {
+		t.Errorf("key-3 with too many failures remembered: err = %v, want %v", err, errKeyFetchLimited)
+	}
+	for _, f := range v.fetches {
+		f.retry = time.Now()
+	}
+	if _, err := v.key(ctx, "eu-west-1", "key-3"); err == nil || err == errKeyFetchLimited {
+		t.Errorf("key-3: err = %v, want fetch error", err)
+	}
+	if len(v.fetches) != 1 {
+		t.Errorf("%d fetches remembered, want 1", len(v.fetches))
+	}
+
+	// malformed key ids are never fetched
+	delete(v.nextFetch, "eu-west-1")
+	for _, id := range []string{"", "../key-1", "key 1", strings.Repeat("k", maxKeyIDLen+1)} {
+		if _, err := v.key(ctx, "eu-west-1", id); err == nil {
+			t.Errorf("key id %q accepted", id)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 5 {
+		t.Errorf("keys fetched %d times, want 5", n)
+	}
+}
+
+type keyFetcherFunc func(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error)
+
+func (f keyFetcherFunc) FetchKey(ctx context.Context, region, keyID string) (*ecdsa.PublicKey, error) {
+	return f(ctx, region, keyID)
+}
+
+func signTestToken(t *testing.T, key *ecdsa.PrivateKey, header, claims map[string]interface{}, padded bool) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	if padded {
+		enc = base64.URLEncoding
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+func tamperTestToken(token string) string {
+	parts := strings.Split(token, ".")
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`))
+	return strings.Join(parts, ".")
+}
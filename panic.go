@@ -0,0 +1,77 @@
+package alb
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicHandler writes response for request whose handler panicked with
+// value v. Anything the handler has written before panicking is discarded.
+type PanicHandler func(w http.ResponseWriter, r *http.Request, v interface{})
+
+// TextPanicHandler responds with plain text 500 Internal Server Error. This is
+// the default PanicHandler.
+func TextPanicHandler(w http.ResponseWriter, _ *http.Request, _ interface{}) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// JSONPanicHandler responds with 500 Internal Server Error and
+// {"error":"Internal Server Error"} JSON body.
+func JSONPanicHandler(w http.ResponseWriter, _ *http.Request, _ interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`{"error":"Internal Server Error"}`))
+}
+
+// WithPanicHandler sets function writing response for requests whose handler
+// panicked. Panics are always recovered from and logged along with the stack
+// trace, unless the panic value is http.ErrAbortHandler: in that case nothing
+// is logged and the invocation fails, which is the closest equivalent of
+// net/http server aborting the connection.
+func WithPanicHandler(f PanicHandler) Option {
+	return func(h *lambdaHandler) { h.panicHandler = f }
+}
+
+// errAborted is returned from the Lambda invocation if handler panicked with
+// http.ErrAbortHandler.
+var errAborted = errors.New("alb: handler aborted request")
+
+// call runs handler recovering from its panics.
+func (h *lambdaHandler) call(handler http.Handler, r *http.Request) (w *responseWriter, err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			w, err = nil, errAborted
+			return
+		}
+		h.logf("alb: panic serving %s %s: %v\n%s", r.Method, r.URL, v, debug.Stack())
+		w = h.panicResponse(r, v)
+	}()
+	w = h.newResponseWriter()
+	handler.ServeHTTP(w, r)
+	return w, nil
+}
+
+// panicResponse records response of the configured PanicHandler, falling back
+// to TextPanicHandler if it panics too.
+func (h *lambdaHandler) panicResponse(r *http.Request, v interface{}) (w *responseWriter) {
+	f := h.panicHandler
+	if f == nil {
+		f = TextPanicHandler
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			h.logf("alb: panic in panic handler: %v", p)
+			w = h.newResponseWriter()
+			TextPanicHandler(w, r, v)
+		}
+	}()
+	w = h.newResponseWriter()
+	f(w, r, v)
+	return w
+}
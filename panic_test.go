@@ -0,0 +1,135 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLambdaHandler_PanicRecovery(t *testing.T) {
+	tests := []struct {
+		name            string
+		handler         http.HandlerFunc
+		panicHandler    PanicHandler
+		wantErr         bool
+		wantStatus      int
+		wantBody        string
+		wantContentType string
+		wantLog         bool
+	}{
+		{
+			name: "panic before headers written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			wantStatus:      http.StatusInternalServerError,
+			wantBody:        "Internal Server Error\n",
+			wantContentType: "text/plain; charset=utf-8",
+			wantLog:         true,
+		},
+		{
+			name: "panic after headers written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("X-Partial", "1")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("<html>partial"))
+				panic("boom")
+			},
+			wantStatus:      http.StatusInternalServerError,
+			wantBody:        "Internal Server Error\n",
+			wantContentType: "text/plain; charset=utf-8",
+			wantLog:         true,
+		},
+		{
+			name: "json panic handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			panicHandler:    JSONPanicHandler,
+			wantStatus:      http.StatusInternalServerError,
+			wantBody:        `{"error":"Internal Server Error"}`,
+			wantContentType: "application/json",
+			wantLog:         true,
+		},
+		{
+			name: "custom panic handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			panicHandler: func(w http.ResponseWriter, r *http.Request, v interface{}) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("recovered: " + v.(string)))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "recovered: boom",
+			wantLog:    true,
+		},
+		{
+			name: "panicking panic handler",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			panicHandler: func(w http.ResponseWriter, r *http.Request, v interface{}) {
+				panic("again")
+			},
+			wantStatus:      http.StatusInternalServerError,
+			wantBody:        "Internal Server Error\n",
+			wantContentType: "text/plain; charset=utf-8",
+			wantLog:         true,
+		},
+		{
+			name: "abort handler before headers written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic(http.ErrAbortHandler)
+			},
+			wantErr: true,
+		},
+		{
+			name: "abort handler after headers written",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("partial"))
+				panic(http.ErrAbortHandler)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			fn := Handler(tt.handler,
+				WithPanicHandler(tt.panicHandler),
+				WithLogger(log.New(&buf, "", 0)))
+			resp, err := fn(context.Background(), Event{Method: "GET", Path: "/"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotLog := strings.Contains(buf.String(), "panic serving GET /: boom"); gotLog != tt.wantLog {
+				t.Errorf("panic logged = %v, want %v, log:\n%s", gotLog, tt.wantLog, buf.String())
+			}
+			if tt.wantLog && !strings.Contains(buf.String(), "goroutine ") {
+				t.Errorf("stack trace not logged:\n%s", buf.String())
+			}
+			if err != nil {
+				return
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.Body != tt.wantBody {
+				t.Errorf("Body = %q, want %q", resp.Body, tt.wantBody)
+			}
+			if got := resp.Headers["Content-Type"]; got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if _, ok := resp.Headers["X-Partial"]; ok {
+				t.Error("headers written before panic leaked into response")
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Start runs h as AWS Lambda function behind ALB, talking to the Lambda
+// Runtime API directly, so that github.com/aws/aws-lambda-go is not needed.
+// Options are applied as with Handler. Start never returns: if
+// initialization fails, it is reported to the Runtime API and the process
+// exits; it also exits on Runtime API communication errors.
+//
+// Handler can be used instead with lambda.Start from
+// github.com/aws/aws-lambda-go/lambda.
+func Start(h http.Handler, opts ...Option) {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		log.Fatal("alb: AWS_LAMBDA_RUNTIME_API is not set, not running inside AWS Lambda?")
+	}
+	log.Fatal(startRuntime(api, h, opts))
+}
+
+// Invocation describes Lambda invocation being processed by a handler run
+// with Start.
+type Invocation struct {
+	RequestID   string    // Lambda-Runtime-Aws-Request-Id
+	Deadline    time.Time // Lambda-Runtime-Deadline-Ms, also set as context deadline
+	FunctionARN string    // Lambda-Runtime-Invoked-Function-Arn
+	TraceID     string    // Lambda-Runtime-Trace-Id
+}
+
+// InvocationFromContext returns Lambda invocation the request is processed
+// as part of. It is only available to handlers run with Start.
+func InvocationFromContext(ctx context.Context) (*Invocation, bool) {
+	inv, ok := ctx.Value(invocationKey{}).(*Invocation)
+	return inv, ok
+}
+
+type invocationKey struct{}
+
+// startRuntime initializes handler and processes invocations until
+// communication with the Runtime API listening on api address fails.
+func startRuntime(api string, h http.Handler, opts []Option) error {
+	c := &runtimeClient{
+		base:   "http://" + api + "/2018-06-01/runtime",
+		client: &http.Client{},
+	}
+	hh, err := initHandler(h, opts)
+	if err != nil {
+		if err := c.postError(c.base+"/init/error", "Runtime.InitError", err); err != nil {
+			log.Printf("alb: reporting init error: %v", err)
+		}
+		return err
+	}
+	for {
+		if err := c.handleNext(hh); err != nil {
+			return err
+		}
+	}
+}
+
+// initHandler is like NewInvoker, but reports panics of options as errors.
+func initHandler(h http.Handler, opts []Option) (hh *lambdaHandler, err error) {
+	if h == nil {
+		return nil, errors.New("alb: Start called with nil handler")
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("alb: initializing handler: %v", p)
+		}
+	}()
+	hh = newLambdaHandler(h, opts)
+	hh.rawJSON = true
+	return hh, nil
+}
+
+type runtimeClient struct {
+	base   string // http://host:port/2018-06-01/runtime
+	client *http.Client
+}
+
+// handleNext fetches the next invocation, handles it with h and posts back
+// the result. Errors of h are reported to the Runtime API and are not
+// returned.
+func (c *runtimeClient) handleNext(h *lambdaHandler) error {
+	res, err := c.client.Get(c.base + "/invocation/next")
+	if err != nil {
+		return err
+	}
+	payload, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("alb: fetching next invocation: unexpected status %s", res.Status)
+	}
+	inv := &Invocation{
+		RequestID:   res.Header.Get("Lambda-Runtime-Aws-Request-Id"),
+		FunctionARN: res.Header.Get("Lambda-Runtime-Invoked-Function-Arn"),
+		TraceID:     res.Header.Get("Lambda-Runtime-Trace-Id"),
+	}
+	if inv.RequestID == "" {
+		return errors.New("alb: next invocation has no request id")
+	}
+	if ms, err := strconv.ParseInt(res.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		inv.Deadline = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	// the variable is process-wide, which matches github.com/aws/aws-lambda-go
+	// handling one invocation at a time
+	if inv.TraceID != "" {
+		os.Setenv("_X_AMZN_TRACE_ID", inv.TraceID)
+	} else {
+		os.Unsetenv("_X_AMZN_TRACE_ID")
+	}
+	url := c.base + "/invocation/" + inv.RequestID
+	var req Event
+	if err := decodeEvent(payload, &req); err != nil {
+		return c.postError(url+"/error", "Runtime.UnmarshalError", fmt.Errorf("decoding event: %w", err))
+	}
+	ctx := context.WithValue(context.Background(), invocationKey{}, inv)
+	cancel := context.CancelFunc(func() {})
+	if !inv.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, inv.Deadline)
+	}
+	resp, err := h.run(ctx, req)
+	cancel()
+	if err != nil {
+		return c.postError(url+"/error", errorType(err), err)
+	}
+	body := appendResponse(make([]byte, 0, resp.size()), resp)
+	resp.release()
+	return c.post(url+"/response", body, nil)
+}
+
+func (c *runtimeClient) postError(url, typ string, err error) error {
+	return c.post(url, errorJSON(typ, err), http.Header{"Lambda-Runtime-Function-Error-Type": {typ}})
+}
+
+// errorJSON returns err of given type serialized as Lambda reports
+// invocation errors.
+func errorJSON(typ string, err error) []byte {
+	body, _ := json.Marshal(struct {
+		Message string `json:"errorMessage"`
+		Type    string `json:"errorType"`
+	}{err.Error(), typ})
+	return body
+}
+
+func (c *runtimeClient) post(url string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("alb: posting to %s: unexpected status %s", url, res.Status)
+	}
+	return nil
+}
+
+// errorType returns name of err type without package qualifier, as reported
+// by other Lambda runtimes.
+func errorType(err error) string {
+	s := strings.TrimLeft(fmt.Sprintf("%T", err), "*")
+	if i := strings.LastIndexByte(s, '.'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
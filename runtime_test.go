@@ -0,0 +1,177 @@
+package alb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRuntime is a stand-in for the Lambda Runtime API serving queued
+// invocations. Once the queue is drained, next invocation requests fail with
+// 410 Gone, which stops the runtime loop.
+type fakeRuntime struct {
+	mu      sync.Mutex
+	queue   []fakeInvocation
+	results map[string]fakeResult
+	initErr *fakeResult
+}
+
+type fakeInvocation struct {
+	id       string
+	deadline time.Time
+	payload  string
+}
+
+type fakeResult struct {
+	kind      string // "response" or "error"
+	errorType string // Lambda-Runtime-Function-Error-Type header
+	body      string
+}
+
+func (f *fakeRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const prefix = "/2018-06-01/runtime/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if path == "invocation/next" && r.Method == http.MethodGet {
+		if len(f.queue) == 0 {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		inv := f.queue[0]
+		f.queue = f.queue[1:]
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", inv.id)
+		w.Header().Set("Lambda-Runtime-Invoked-Function-Arn", "arn:aws:lambda:us-east-1:123456789012:function:test")
+		w.Header().Set("Lambda-Runtime-Trace-Id", "Root=1-5759e988-bd862e3fe1be46a994272793")
+		if !inv.deadline.IsZero() {
+			w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(inv.deadline.UnixNano()/int64(time.Millisecond), 10))
+		}
+		io.WriteString(w, inv.payload)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	b, _ := io.ReadAll(r.Body)
+	res := fakeResult{errorType: r.Header.Get("Lambda-Runtime-Function-Error-Type"), body: string(b)}
+	switch parts := strings.Split(path, "/"); {
+	case path == "init/error":
+		res.kind = "error"
+		f.initErr = &res
+	case len(parts) == 3 && parts[0] == "invocation" && (parts[2] == "response" || parts[2] == "error"):
+		res.kind = parts[2]
+		f.results[parts[1]] = res
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func runFakeRuntime(t *testing.T, h http.Handler, opts []Option, queue ...fakeInvocation) *fakeRuntime {
+	t.Helper()
+	// the runtime sets _X_AMZN_TRACE_ID for every invocation, restore it
+	// once the test is done
+	t.Setenv("_X_AMZN_TRACE_ID", os.Getenv("_X_AMZN_TRACE_ID"))
+	f := &fakeRuntime{queue: queue, results: make(map[string]fakeResult)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	err := startRuntime(strings.TrimPrefix(srv.URL, "http://"), h, opts)
+	if err == nil {
+		t.Fatal("startRuntime returned nil error")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.initErr == nil && !strings.Contains(err.Error(), "410 Gone") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return f
+}
+
+func TestStartRuntime(t *testing.T) {
+	deadline := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := InvocationFromContext(r.Context())
+		if !ok {
+			t.Error("no invocation in context")
+			return
+		}
+		d, _ := r.Context().Deadline()
+		fmt.Fprintf(w, "%s %s %v %s", r.URL.Path, inv.RequestID, d.Equal(inv.Deadline), os.Getenv("_X_AMZN_TRACE_ID"))
+	})
+	f := runFakeRuntime(t, handler, nil,
+		fakeInvocation{id: "req-1", deadline: deadline, payload: `{"httpMethod":"GET","path":"/hello"}`},
+		fakeInvocation{id: "req-2", payload: `{"httpMethod":`},
+		fakeInvocation{id: "req-3", deadline: deadline, payload: `{"httpMethod":"GET","path":"/bye"}`},
+	)
+	for id, want := range map[string]string{
+		"req-1": "/hello req-1 true Root=1-5759e988-bd862e3fe1be46a994272793",
+		"req-3": "/bye req-3 true Root=1-5759e988-bd862e3fe1be46a994272793",
+	} {
+		res := f.results[id]
+		if res.kind != "response" {
+			t.Errorf("%s: got %s result: %s", id, res.kind, res.body)
+			continue
+		}
+		var resp Response
+		if err := json.Unmarshal([]byte(res.body), &resp); err != nil {
+			t.Fatalf("%s: decoding response: %v", id, err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Body != want {
+			t.Errorf("%s: got %d %q, want 200 %q", id, resp.StatusCode, resp.Body, want)
+		}
+	}
+	if res := f.results["req-2"]; res.kind != "error" || res.errorType != "Runtime.UnmarshalError" ||
+		!strings.Contains(res.body, `"errorMessage":"decoding event: `) {
+		t.Errorf("req-2: got %+v, want error report", res)
+	}
+}
+
+func TestStartRuntime_HandlerError(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	f := runFakeRuntime(t, handler, nil,
+		fakeInvocation{id: "req-1", payload: `{"httpMethod":"GET","path":"/","body":"!","isBase64Encoded":true}`},
+	)
+	if res := f.results["req-1"]; res.kind != "error" || res.errorType != "EventError" {
+		t.Errorf("got %+v, want EventError report", res)
+	}
+}
+
+func TestStartRuntime_InitError(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+		opts    []Option
+	}{
+		{name: "nil handler"},
+		{
+			name:    "panicking option",
+			handler: http.NotFoundHandler(),
+			opts:    []Option{WithOIDC(OIDCConfig{})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := runFakeRuntime(t, tt.handler, tt.opts, fakeInvocation{id: "req-1", payload: `{}`})
+			if f.initErr == nil || f.initErr.errorType != "Runtime.InitError" {
+				t.Fatalf("got init error %+v, want Runtime.InitError report", f.initErr)
+			}
+			if len(f.queue) != 1 {
+				t.Error("invocation processed despite init error")
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package alb
+
+import (
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DefaultResponseLimit is the maximum size of the response JSON Lambda
+// function can send to ALB.
+const DefaultResponseLimit = 1 << 20
+
+// WithResponseLimit sets maximum size of the response serialized for ALB,
+// n <= 0 disables the check. The default is DefaultResponseLimit.
+//
+// The size is computed for the response serialized by Start or Invoker,
+// which do not escape HTML characters. Responses returned by the function
+// from Handler are assumed to be serialized with encoding/json, which
+// escapes <, > and & characters as six-byte sequences.
+//
+// Once the body written by the handler is known to exceed the limit, it is
+// discarded and further writes fail with ErrResponseTooLarge. The client then
+// gets response written by the handler set with WithOversizeHandler.
+func WithResponseLimit(n int) Option {
+	return func(h *lambdaHandler) {
+		if n <= 0 {
+			n = -1
+		}
+		h.responseLimit = n
+	}
+}
+
+// WithOversizeHandler sets handler writing response in place of the one that
+// exceeded the limit set with WithResponseLimit. The default one responds
+// with plain text 500 Internal Server Error.
+func WithOversizeHandler(oh http.Handler) Option {
+	return func(h *lambdaHandler) { h.oversizeHandler = oh }
+}
+
+func (h *lambdaHandler) maxResponseSize() int {
+	if h.responseLimit == 0 {
+		return DefaultResponseLimit
+	}
+	return h.responseLimit
+}
+
+// responseSize returns size of r serialized for ALB.
+func (h *lambdaHandler) responseSize(r *Response) int {
+	if h.rawJSON {
+		return r.size()
+	}
+	return r.marshaledSize()
+}
+
+// size returns length of r serialized with appendResponse.
+func (r *Response) size() int { return r.jsonSize(false) }
+
+// marshaledSize returns length of r serialized with encoding/json, which
+// also escapes <, > and & characters.
+func (r *Response) marshaledSize() int { return r.jsonSize(true) }
+
+func (r *Response) jsonSize(html bool) int {
+	n := len(`{"statusCode":`) + len(strconv.Itoa(r.StatusCode)) +
+		len(`,"statusDescription":`) + jsonStringLen(r.Status, html) +
+		len(`,"headers":`) + len(`,"multiValueHeaders":`) +
+		len(`,"body":`) +
+		len(`,"isBase64Encoded":`) + len("false") + len(`}`)
+	if r.BodyEncoded {
+		n--
+	}
+	if r.raw != nil {
+		n += base64Len(r.raw.Len()) + len(`""`)
+	} else {
+		n += jsonStringLen(r.Body, html)
+	}
+	if r.Headers == nil {
+		n += len("null")
+	} else {
+		n += len("{}")
+		for k, v := range r.Headers {
+			n += jsonStringLen(k, html) + len(":") + jsonStringLen(v, html) + len(",")
+		}
+		if len(r.Headers) != 0 {
+			n--
+		}
+	}
+	if r.MultiValueHeaders == nil {
+		n += len("null")
+	} else {
+		n += len("{}")
+		for k, vv := range r.MultiValueHeaders {
+			n += jsonStringLen(k, html) + len(":") + len(",")
+			if vv == nil {
+				n += len("null")
+				continue
+			}
+			n += len("[]")
+			for _, v := range vv {
+				n += jsonStringLen(v, html) + len(",")
+			}
+			if len(vv) != 0 {
+				n--
+			}
+		}
+		if len(r.MultiValueHeaders) != 0 {
+			n--
+		}
+	}
+	return n
+}
+
+// jsonStringLen returns length of s encoded as JSON string, including
+// quotes. Escaping is minimal as done by appendJSONString, or matches
+// encoding/json if html is set.
+func jsonStringLen(s string, html bool) int {
+	n := len(`""`)
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			n += jsonByteLen(c, html)
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		n += jsonRuneLen(r, size, html)
+		i += size
+	}
+	return n
+}
+
+// jsonByteLen returns length of ASCII character c escaped in JSON string.
+func jsonByteLen(c byte, html bool) int {
+	switch {
+	case c == '"' || c == '\\':
+		return 2
+	case c == '\b' || c == '\f' || c == '\n' || c == '\r' || c == '\t':
+		return 2
+	case c < 0x20:
+		return len(`\u0000`)
+	case html && (c == '<' || c == '>' || c == '&'):
+		return len(`\u0000`)
+	}
+	return 1
+}
+
+// jsonRuneLen returns length of non-ASCII rune r of given encoded size
+// escaped in JSON string. Invalid UTF-8 is replaced with U+FFFD.
+func jsonRuneLen(r rune, size int, html bool) int {
+	switch {
+	case r == utf8.RuneError && size == 1:
+		return len(string(utf8.RuneError))
+	case html && (r == '\u2028' || r == '\u2029'):
+		return len(`\u2028`)
+	}
+	return size
+}
+
+// base64Len returns length of n bytes encoded with padded base64.
+func base64Len(n int) int { return (n + 2) / 3 * 4 }
+
+// bodySize tracks the size written body will have in the response JSON,
+// either as a string if it turns out to be valid UTF-8, or base64-encoded
+// otherwise.
+type bodySize struct {
+	n       int     // total bytes written
+	text    int     // JSON string length of the valid UTF-8 prefix, sans quotes
+	invalid bool    // body is known not to be valid UTF-8
+	pending [3]byte // incomplete rune at the end of the written data
+	npend   int
+}
+
+func (s *bodySize) Write(b []byte) {
+	s.n += len(b)
+	if s.invalid {
+		return
+	}
+	if s.npend != 0 {
+		var buf [utf8.UTFMax]byte
+		k := copy(buf[:], s.pending[:s.npend])
+		k += copy(buf[k:], b)
+		if !utf8.FullRune(buf[:k]) {
+			s.npend = copy(s.pending[:], buf[:k])
+			return
+		}
+		r, size := utf8.DecodeRune(buf[:k])
+		if r == utf8.RuneError && size == 1 {
+			s.invalid = true
+			return
+		}
+		s.text += jsonRuneLen(r, size, false)
+		b = b[size-s.npend:]
+		s.npend = 0
+	}
+	for i := 0; i < len(b); {
+		if c := b[i]; c < utf8.RuneSelf {
+			s.text += jsonByteLen(c, false)
+			i++
+			continue
+		}
+		if !utf8.FullRune(b[i:]) {
+			s.npend = copy(s.pending[:], b[i:])
+			return
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			s.invalid = true
+			return
+		}
+		s.text += jsonRuneLen(r, size, false)
+		i += size
+	}
+}
+
+// Min returns the smallest size written body can take in the response JSON,
+// including quotes.
+func (s *bodySize) Min() int {
+	n := base64Len(s.n)
+	if !s.invalid && s.text < n {
+		n = s.text
+	}
+	return n + len(`""`)
+}
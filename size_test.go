@@ -0,0 +1,285 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestResponse_Size(t *testing.T) {
+	tests := []struct {
+		name string
+		resp Response
+	}{
+		{
+			name: "empty",
+			resp: Response{},
+		},
+		{
+			name: "single-value headers",
+			resp: Response{
+				StatusCode: http.StatusOK,
+				Status:     "200 OK",
+				Headers:    map[string]string{"Content-Type": "text/html", "X-Quote": `"a" & <b>`},
+				Body:       "<html><body>Hello & welcome</body></html>\n",
+			},
+		},
+		{
+			name: "multi-value headers",
+			resp: Response{
+				StatusCode:        http.StatusNotFound,
+				Status:            "404 Not Found",
+				MultiValueHeaders: map[string][]string{"Set-Cookie": {"a=1", "b=2"}, "X-Empty": {}, "X-Nil": nil},
+				Body:              "not found",
+			},
+		},
+		{
+			name: "empty header maps",
+			resp: Response{
+				StatusCode:        http.StatusNoContent,
+				Headers:           map[string]string{},
+				MultiValueHeaders: map[string][]string{},
+			},
+		},
+		{
+			name: "escapes",
+			resp: Response{
+				StatusCode: http.StatusOK,
+				Headers:    map[string]string{},
+				Body:       "tab\tnewline\nquote\"backslash\\bell\x07del\x7f\b\f\r",
+			},
+		},
+		{
+			name: "unicode",
+			resp: Response{
+				StatusCode: http.StatusOK,
+				Body:       "Hello, 世界! 🌍    é",
+			},
+		},
+		{
+			name: "invalid utf8",
+			resp: Response{
+				StatusCode: http.StatusOK,
+				Body:       "a\xffb\xe4\xb8",
+			},
+		},
+		{
+			name: "base64 body",
+			resp: Response{
+				StatusCode:  http.StatusOK,
+				Body:        base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 0xff}),
+				BodyEncoded: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(&tt.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.resp.marshaledSize(); got != len(b) {
+				t.Errorf("marshaledSize() = %d, want %d for %s", got, len(b), b)
+			}
+			b = appendResponse(nil, &tt.resp)
+			if got := tt.resp.size(); got != len(b) {
+				t.Errorf("size() = %d, want %d for %s", got, len(b), b)
+			}
+		})
+	}
+}
+
+func TestBodySize(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantInvalid bool
+	}{
+		{in: ""},
+		{in: "plain ascii"},
+		{in: "<html>&amp;</html>"},
+		{in: "Hello, 世界! 🌍 \u2028 \u2029"},
+		{in: "a\xffb", wantInvalid: true},
+		{in: "\xe4\xb8\x96\xf0\x9f\x8cx", wantInvalid: true},
+		{in: "ends mid rune \xe4\xb8"},
+	}
+	for _, tt := range tests {
+		final := len(`""`) + base64Len(len(tt.in))
+		if utf8.ValidString(tt.in) {
+			final = jsonStringLen(tt.in, false)
+		}
+		for split := 0; split <= len(tt.in); split++ {
+			var s bodySize
+			s.Write([]byte(tt.in[:split]))
+			s.Write([]byte(tt.in[split:]))
+			if s.invalid != tt.wantInvalid {
+				t.Errorf("%q split at %d: invalid = %v, want %v", tt.in, split, s.invalid, tt.wantInvalid)
+			}
+			got := s.Min()
+			if got > final {
+				t.Errorf("%q split at %d: Min() = %d exceeds final size %d", tt.in, split, got, final)
+			}
+			want := len(`""`) + base64Len(len(tt.in))
+			if utf8.ValidString(tt.in) && final < want {
+				want = final
+			}
+			if (tt.wantInvalid || utf8.ValidString(tt.in)) && got != want {
+				t.Errorf("%q split at %d: Min() = %d, want %d", tt.in, split, got, want)
+			}
+		}
+	}
+}
+
+func TestLambdaHandler_ResponseLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		body       string
+		chunk      int
+		wantStatus int
+		wantBody   string
+		wantErrs   bool
+		wantLog    bool
+	}{
+		{
+			name:       "within limit",
+			opts:       []Option{WithResponseLimit(1000)},
+			body:       strings.Repeat("x", 800),
+			wantStatus: http.StatusOK,
+			wantBody:   strings.Repeat("x", 800),
+		},
+		{
+			name:       "body exceeds limit while writing",
+			opts:       []Option{WithResponseLimit(1000)},
+			body:       strings.Repeat("x", 2000),
+			chunk:      100,
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+			wantErrs:   true,
+			wantLog:    true,
+		},
+		{
+			name:       "escaping pushes body over limit",
+			opts:       []Option{WithResponseLimit(1000)},
+			body:       strings.Repeat("<", 300),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+			wantLog:    true,
+		},
+		{
+			name:       "serialized response exceeds limit",
+			opts:       []Option{WithResponseLimit(1000)},
+			body:       strings.Repeat("x", 950),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+			wantLog:    true,
+		},
+		{
+			name: "custom oversize handler",
+			opts: []Option{WithResponseLimit(100), WithOversizeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+			}))},
+			body:       strings.Repeat("x", 200),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "too large\n",
+			wantErrs:   true,
+			wantLog:    true,
+		},
+		{
+			name:       "limit disabled",
+			opts:       []Option{WithResponseLimit(0)},
+			body:       strings.Repeat("x", DefaultResponseLimit+1),
+			wantStatus: http.StatusOK,
+			wantBody:   strings.Repeat("x", DefaultResponseLimit+1),
+		},
+		{
+			name:       "default limit",
+			body:       strings.Repeat("x", DefaultResponseLimit+1),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+			wantErrs:   true,
+			wantLog:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var gotErr error
+			opts := append([]Option{WithLogger(log.New(&buf, "", 0))}, tt.opts...)
+			fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				chunk := tt.chunk
+				if chunk == 0 {
+					chunk = len(tt.body)
+				}
+				for i := 0; i < len(tt.body); i += chunk {
+					end := i + chunk
+					if end > len(tt.body) {
+						end = len(tt.body)
+					}
+					if _, err := w.Write([]byte(tt.body[i:end])); err != nil {
+						gotErr = err
+					}
+				}
+			}), opts...)
+			resp, err := fn(context.Background(), Event{Method: "GET", Path: "/"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.Body != tt.wantBody {
+				t.Errorf("Body = %.40q, want %.40q", resp.Body, tt.wantBody)
+			}
+			if tt.wantErrs && gotErr != ErrResponseTooLarge {
+				t.Errorf("Write error = %v, want %v", gotErr, ErrResponseTooLarge)
+			}
+			if !tt.wantErrs && gotErr != nil {
+				t.Errorf("unexpected Write error: %v", gotErr)
+			}
+			if gotLog := strings.Contains(buf.String(), "exceeding the limit"); gotLog != tt.wantLog {
+				t.Errorf("diagnostic logged = %v, want %v, log: %q", gotLog, tt.wantLog, buf.String())
+			}
+		})
+	}
+}
+
+func TestLambdaHandler_ResponseLimitHTML(t *testing.T) {
+	// every row grows by 35 bytes if <, > and & are escaped
+	page := "<table>\n" + strings.Repeat("<tr><td>a &amp; b</td></tr>\n", 30000) + "</table>\n"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	})
+	logger := log.New(io.Discard, "", 0)
+
+	resp, err := Handler(handler, WithLogger(logger))(context.Background(), Event{Method: "GET", Path: "/"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("encoding/json serialized response: status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	out, err := NewInvoker(handler, WithLogger(logger)).Invoke(context.Background(), []byte(`{"httpMethod":"GET","path":"/"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) > DefaultResponseLimit {
+		t.Errorf("response is %d bytes, exceeding %d bytes limit", len(out), DefaultResponseLimit)
+	}
+	var got Response
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StatusCode != http.StatusOK || got.Body != page {
+		t.Errorf("got status %d and %d bytes body, want %d and %d bytes", got.StatusCode, len(got.Body), http.StatusOK, len(page))
+	}
+}
@@ -0,0 +1,125 @@
+package alb
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithDeadlineMargin makes handler time out d before the Lambda invocation
+// deadline: request context is canceled, and the client gets response
+// written by the handler set with WithTimeoutHandler instead of whatever the
+// original handler has written so far. This gives a chance to reply before
+// Lambda kills the invocation, which ALB reports as 502 Bad Gateway.
+//
+// The timed out handler keeps running in its own goroutine until it returns,
+// but its writes fail with http.ErrHandlerTimeout. Handlers are expected to
+// stop once request context is canceled.
+func WithDeadlineMargin(d time.Duration) Option {
+	return func(h *lambdaHandler) { h.deadlineMargin = d }
+}
+
+// WithTimeoutHandler sets handler writing response for requests that timed
+// out as configured with WithDeadlineMargin. The default one responds with
+// plain text 504 Gateway Timeout. The handler gets a copy of the request
+// without body, its context is that of the invocation, not canceled by the
+// timeout.
+func WithTimeoutHandler(th http.Handler) Option {
+	return func(h *lambdaHandler) { h.timeoutHandler = th }
+}
+
+// callWithDeadline is like call, but gives up on handler deadlineMargin before
+// the request context deadline.
+func (h *lambdaHandler) callWithDeadline(handler http.Handler, r *http.Request) (*responseWriter, error) {
+	deadline, ok := r.Context().Deadline()
+	if h.deadlineMargin <= 0 || !ok {
+		return h.call(handler, r)
+	}
+	// request context is only canceled after writes are disabled, so that
+	// handler observing cancelation can rely on its writes failing
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	timer := time.NewTimer(time.Until(deadline.Add(-h.deadlineMargin)))
+	defer timer.Stop()
+	parent := r.Context()
+	r = r.WithContext(ctx)
+	// the timed out handler may still be using r, so the timeout handler
+	// gets its own copy, taken before the handler can modify r, with the
+	// context that is not canceled on timeout
+	tr := r.Clone(parent)
+	tr.Body = http.NoBody
+	tr.ContentLength = 0
+
+	type result struct {
+		w   *responseWriter
+		err error
+	}
+	done := make(chan result, 1)
+	tw := &timeoutWriter{}
+	go func() {
+		w, err := h.call(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw.w = w
+			handler.ServeHTTP(tw, r)
+		}), r)
+		done <- result{w: w, err: err}
+	}()
+	select {
+	case res := <-done:
+		return res.w, res.err
+	case <-timer.C:
+	case <-parent.Done():
+	}
+	tw.mu.Lock()
+	tw.timedOut = true
+	tw.mu.Unlock()
+	cancel()
+	h.logf("alb: handler for %s %s timed out %v before invocation deadline", tr.Method, tr.URL, h.deadlineMargin)
+
+	th := h.timeoutHandler
+	if th == nil {
+		th = errorHandler(http.StatusGatewayTimeout)
+	}
+	return h.call(th, tr)
+}
+
+// timeoutWriter passes writes through until the handler times out, and
+// fails them afterwards. Once timedOut is set, the underlying writer is no
+// longer accessed by anyone but the handler goroutine.
+type timeoutWriter struct {
+	w http.ResponseWriter // only accessed from the handler goroutine
+
+	mu       sync.Mutex
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.w.Header() }
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.w }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.w.WriteHeader(code)
+}
@@ -0,0 +1,159 @@
+package alb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLambdaHandler_DeadlineMargin(t *testing.T) {
+	t.Run("handler completes in time", func(t *testing.T) {
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Done", "1")
+			w.Write([]byte("done"))
+		}), WithDeadlineMargin(10*time.Millisecond))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := fn(ctx, Event{Method: "GET", Path: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || resp.Body != "done" || resp.Headers["X-Done"] != "1" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	})
+
+	t.Run("handler times out", func(t *testing.T) {
+		writeErr := make(chan error, 1)
+		var buf bytes.Buffer
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Partial", "1")
+			w.Write([]byte("partial"))
+			<-r.Context().Done()
+			w.Header().Set("X-Late", "1")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte("late"))
+			writeErr <- err
+		}), WithDeadlineMargin(time.Minute-50*time.Millisecond), WithLogger(log.New(&buf, "", 0)))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := fn(ctx, Event{Method: "GET", Path: "/slow"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+		}
+		if resp.Body != "Gateway Timeout\n" {
+			t.Errorf("Body = %q, want %q", resp.Body, "Gateway Timeout\n")
+		}
+		if _, ok := resp.Headers["X-Partial"]; ok {
+			t.Error("headers written before timeout leaked into response")
+		}
+		if err := <-writeErr; err != http.ErrHandlerTimeout {
+			t.Errorf("late Write error = %v, want %v", err, http.ErrHandlerTimeout)
+		}
+		if !strings.Contains(buf.String(), "GET /slow timed out") {
+			t.Errorf("timeout not logged: %q", buf.String())
+		}
+	})
+
+	t.Run("custom timeout handler", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			w.Write([]byte("ignored context"))
+		}),
+			WithDeadlineMargin(time.Minute-50*time.Millisecond),
+			WithTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.Context().Err(); err != nil {
+					t.Errorf("timeout handler context: %v", err)
+				}
+				if _, ok := EventFromContext(r.Context()); !ok {
+					t.Error("no event in timeout handler context")
+				}
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})),
+			WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := fn(ctx, Event{Method: "GET", Path: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Headers["Retry-After"] != "1" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	})
+
+	t.Run("timeout handler gets own request", func(t *testing.T) {
+		timedOut := make(chan struct{})
+		done := make(chan struct{})
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(done)
+			<-r.Context().Done()
+			<-timedOut
+			r.Header.Set("X-Late", "1")
+			io.Copy(io.Discard, r.Body)
+		}),
+			WithDeadlineMargin(time.Minute-50*time.Millisecond),
+			WithTimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(timedOut)
+				<-done
+				if r.Header.Get("X-Late") != "" || r.Header.Get("X-Early") != "1" {
+					t.Errorf("timeout handler got header %v", r.Header)
+				}
+				if r.Body != http.NoBody {
+					t.Error("timeout handler got request body")
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})),
+			WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := fn(ctx, Event{Method: "POST", Path: "/", Headers: map[string]string{"X-Early": "1"}, Body: "body"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("handler panics before deadline", func(t *testing.T) {
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), WithDeadlineMargin(10*time.Millisecond), WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp, err := fn(ctx, Event{Method: "GET", Path: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		fn := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Deadline(); ok {
+				t.Error("unexpected request context deadline")
+			}
+		}), WithDeadlineMargin(time.Second))
+		resp, err := fn(context.Background(), Event{Method: "GET", Path: "/"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	})
+}
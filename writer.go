@@ -0,0 +1,217 @@
+package alb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrResponseTooLarge is returned from response body writes once the body is
+// known to exceed the limit set with WithResponseLimit.
+var ErrResponseTooLarge = errors.New("alb: response exceeds size limit")
+
+// responseWriter buffers handler response in memory, tracking the size its
+// body will have in the response JSON. Writers and their buffers are pooled,
+// see release.
+//
+// Besides http.ResponseWriter, it implements http.Flusher and io.ReaderFrom,
+// and the methods http.ResponseController looks for. Flushing only commits
+// the header, as the response is passed to ALB once the handler returns.
+type responseWriter struct {
+	handlerHeader http.Header // header map the handler modifies
+	header        http.Header // snapshot of handlerHeader taken once written
+	code          int
+	wroteHeader   bool
+	body          *bytes.Buffer
+	limit         int // maximum body size in the response JSON, no limit if negative
+	size          bodySize
+	exceeded      bool // body was discarded as it exceeded the limit
+	encoded       bool // body must be sent base64-encoded
+}
+
+var (
+	writerPool = sync.Pool{New: func() interface{} { return new(responseWriter) }}
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// maxPooledBuffer is the capacity of buffers too large to keep in the pool.
+const maxPooledBuffer = 2 * DefaultResponseLimit
+
+func getBuffer() *bytes.Buffer { return bufferPool.Get().(*bytes.Buffer) }
+
+func putBuffer(b *bytes.Buffer) {
+	if b == nil || b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
+func (h *lambdaHandler) newResponseWriter() *responseWriter {
+	w := writerPool.Get().(*responseWriter)
+	w.body = getBuffer()
+	w.limit = h.maxResponseSize()
+	if h.blobStore != nil || h.compression != nil {
+		// oversized body is needed in full to store it, or may fit once
+		// compressed
+		w.limit = -1
+	}
+	return w
+}
+
+// release returns w to the pool. Neither w nor its body may be used
+// afterwards, header maps are not reused.
+func (w *responseWriter) release() {
+	putBuffer(w.body)
+	*w = responseWriter{}
+	writerPool.Put(w)
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.handlerHeader == nil {
+		w.handlerHeader = make(http.Header)
+	}
+	return w.handlerHeader
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("invalid WriteHeader code %v", code))
+	}
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		// informational responses cannot be passed through ALB
+		return
+	}
+	w.code = code
+	w.wroteHeader = true
+	w.header = w.Header().Clone()
+}
+
+// finish completes the response if the handler wrote nothing, and returns
+// its status code and header. Changes to the returned header are seen by
+// subsequent calls.
+func (w *responseWriter) finish() (int, http.Header) {
+	if !w.wroteHeader {
+		w.code = http.StatusOK
+		w.wroteHeader = true
+		w.header = w.Header()
+	}
+	return w.code, w.header
+}
+
+// bytes returns the buffered body.
+func (w *responseWriter) bytes() []byte {
+	if w.body == nil {
+		return nil
+	}
+	return w.body.Bytes()
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if _, ok := w.Header()["Content-Type"]; !ok && w.Header().Get("Transfer-Encoding") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if !bodyAllowed(w.code) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if w.exceeded {
+		return 0, ErrResponseTooLarge
+	}
+	w.size.Write(b)
+	if w.limit >= 0 && w.size.Min() > w.limit {
+		w.exceeded = true
+		putBuffer(w.body)
+		w.body = nil
+		return 0, ErrResponseTooLarge
+	}
+	return w.body.Write(b)
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// readChunk is the size of reads ReadFrom makes.
+const readChunk = 32 << 10
+
+// ReadFrom implements io.ReaderFrom, reading directly into the body buffer.
+func (w *responseWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	for {
+		if w.exceeded {
+			return n, ErrResponseTooLarge
+		}
+		w.body.Grow(readChunk)
+		b := w.body.Bytes()
+		buf := b[len(b) : len(b)+readChunk]
+		m, rerr := src.Read(buf)
+		if m > 0 {
+			// buf is the free space past the end of the body, so writing
+			// it does not copy
+			m, err := w.Write(buf[:m])
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Flush implements http.Flusher, it commits the header.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Hijack is called by http.ResponseController, connections cannot be
+// hijacked behind ALB.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, fmt.Errorf("alb: %w: cannot hijack connection of Lambda invocation", http.ErrNotSupported)
+}
+
+// SetReadDeadline is called by http.ResponseController. The request is read
+// in full before the handler runs, so there is no deadline to set.
+func (w *responseWriter) SetReadDeadline(time.Time) error {
+	return fmt.Errorf("alb: %w: request body is already read", http.ErrNotSupported)
+}
+
+// SetWriteDeadline is called by http.ResponseController. The response is
+// sent once the handler returns, so writes are bound by the invocation
+// deadline only, see WithDeadlineMargin.
+func (w *responseWriter) SetWriteDeadline(time.Time) error {
+	return fmt.Errorf("alb: %w: response is sent once handler returns", http.ErrNotSupported)
+}
+
+// EnableFullDuplex is called by http.ResponseController. The request body
+// is buffered in memory, so it can be read after the response is written.
+func (w *responseWriter) EnableFullDuplex() error { return nil }
+
+// bodyAllowed reports whether response with given status code may have a
+// body.
+func bodyAllowed(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package alb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseWriter(t *testing.T) {
+	large := strings.Repeat("<p>hello</p>", 10000)
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		opts       []Option
+		wantStatus int
+		wantBody   string
+		wantHeader http.Header
+	}{
+		{
+			name: "header snapshot on write",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Before", "1")
+				io.WriteString(w, "hello")
+				w.Header().Set("X-After", "1")
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "hello",
+			wantHeader: http.Header{"X-Before": {"1"}, "X-After": nil, "Content-Type": {"text/plain; charset=utf-8"}},
+		},
+		{
+			name: "flush commits header",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if err := http.NewResponseController(w).Flush(); err != nil {
+					t.Errorf("Flush: %v", err)
+				}
+				w.WriteHeader(http.StatusTeapot)
+				w.Header().Set("X-After", "1")
+				io.WriteString(w, "hello")
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "hello",
+			wantHeader: http.Header{"X-After": nil},
+		},
+		{
+			name: "informational status ignored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Link", "</style.css>; rel=preload")
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusCreated)
+			},
+			wantStatus: http.StatusCreated,
+			wantHeader: http.Header{"Link": {"</style.css>; rel=preload"}},
+		},
+		{
+			name: "body not allowed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+				if _, err := io.WriteString(w, "hello"); err != http.ErrBodyNotAllowed {
+					t.Errorf("got write error %v, want %v", err, http.ErrBodyNotAllowed)
+				}
+			},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name: "read from",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				n, err := io.Copy(w, struct{ io.Reader }{strings.NewReader(large)})
+				if n != int64(len(large)) || err != nil {
+					t.Errorf("Copy = %d, %v, want %d, nil", n, err, len(large))
+				}
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   large,
+			wantHeader: http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		},
+		{
+			name: "read from over limit",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, err := io.Copy(w, struct{ io.Reader }{strings.NewReader(large)})
+				if err != ErrResponseTooLarge {
+					t.Errorf("got copy error %v, want %v", err, ErrResponseTooLarge)
+				}
+			},
+			opts:       []Option{WithResponseLimit(1000)},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Internal Server Error\n",
+		},
+		{
+			name: "response controller",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				rc := http.NewResponseController(w)
+				if _, _, err := rc.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+					t.Errorf("Hijack error = %v, want http.ErrNotSupported", err)
+				}
+				if err := rc.SetWriteDeadline(time.Now()); !errors.Is(err, http.ErrNotSupported) {
+					t.Errorf("SetWriteDeadline error = %v, want http.ErrNotSupported", err)
+				}
+				if err := rc.SetReadDeadline(time.Now()); !errors.Is(err, http.ErrNotSupported) {
+					t.Errorf("SetReadDeadline error = %v, want http.ErrNotSupported", err)
+				}
+				if err := rc.EnableFullDuplex(); err != nil {
+					t.Errorf("EnableFullDuplex error = %v", err)
+				}
+				io.WriteString(w, "hello")
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "hello",
+		},
+	}
+	for _, tt := range tests {
+		for _, margin := range []time.Duration{0, time.Second} {
+			name := tt.name
+			if margin != 0 {
+				name += " with deadline margin"
+			}
+			t.Run(name, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				opts := append([]Option{WithDeadlineMargin(margin), WithLogger(log.New(io.Discard, "", 0))}, tt.opts...)
+				resp, err := Handler(tt.handler, opts...)(ctx, Event{Method: "GET", Path: "/"})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+				if resp.Body != tt.wantBody {
+					t.Errorf("body = %.40q, want %.40q", resp.Body, tt.wantBody)
+				}
+				for k, vv := range tt.wantHeader {
+					got, ok := resp.Headers[k]
+					if vv == nil && ok {
+						t.Errorf("unexpected header %s: %q", k, got)
+					} else if vv != nil && got != vv[0] {
+						t.Errorf("header %s = %q, want %q", k, got, vv[0])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestResponseWriter_Release(t *testing.T) {
+	h := &lambdaHandler{}
+	w := h.newResponseWriter()
+	var _ io.ReaderFrom = w
+	var _ http.Flusher = w
+	w.Header().Set("X-Test", "1")
+	w.WriteHeader(http.StatusTeapot)
+	io.WriteString(w, "hello")
+	w.encoded = true
+	w.release()
+	for i := 0; i < 10; i++ {
+		w := h.newResponseWriter()
+		if code, header := w.finish(); code != http.StatusOK || len(header) != 0 || len(w.bytes()) != 0 ||
+			w.encoded || w.size.n != 0 {
+			t.Fatalf("reused writer not reset: %d %v %q", code, header, w.bytes())
+		}
+		w.release()
+	}
+}